@@ -6,6 +6,8 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"path/filepath"
 	"strings"
 
 	"github.com/Shanjm/tracing-aspect/log"
@@ -14,18 +16,25 @@ import (
 	"golang.org/x/tools/go/ssa/ssautil"
 )
 
-// ParseProject 解析项目代码，入参为项目根目录，返回项目解析结果
-func ParseProject(propath string) (*Project, error) {
-	program, ssaPkgs, err := buildSSA(propath)
+// ParseProject 解析项目代码，入参为项目根目录与是否包含测试，返回项目解析结果
+func ParseProject(propath string, tests bool) (*Project, error) {
+	propath, err := filepath.Abs(propath)
+	if err != nil {
+		return nil, err
+	}
+	program, ssaPkgs, pkgs, err := buildSSA(propath, tests)
 	if err != nil {
 		return nil, err
 	}
 	mains := ssautil.MainPackages(ssaPkgs)
 
 	rootPkg := ""
-	if len(mains) > 0 {
-		// 选第一个 main 包作为根包
-		rootPkg = mains[0].Pkg.Path()
+	for _, m := range mains {
+		// 选第一个 main 包作为根包，go test 生成的 main 包除外
+		if !strings.HasSuffix(m.Pkg.Path(), ".test") {
+			rootPkg = m.Pkg.Path()
+			break
+		}
 	}
 
 	p := &Project{
@@ -34,8 +43,28 @@ func ParseProject(propath string) (*Project, error) {
 		SsaPkgs:    ssaPkgs,
 		Pm:         make(map[string]*Package),
 		Rely:       make(map[string]string),
+		Info:       make(map[*types.Package]*types.Info),
 		wrappers:   []*ssa.Function{},
 	}
+	for _, pkg := range pkgs {
+		if pkg.Module != nil && p.Module == "" {
+			p.Module = pkg.Module.Path
+			p.ModuleDir = pkg.Module.Dir
+		}
+		if pkg.Types != nil && pkg.TypesInfo != nil {
+			p.Info[pkg.Types] = pkg.TypesInfo
+		}
+	}
+	for _, pkg := range pkgs {
+		// 依赖中的信号处理不代表程序自己处理，只看模块中的包
+		if pkg.Module != nil && pkg.Module.Path == p.Module && handlesSignals(pkg) {
+			p.Signals = append(p.Signals, pkg.PkgPath)
+		}
+	}
+	if p.RootPkg == "" {
+		// 没有 main 包，如只有测试的库，以模块为根包
+		p.RootPkg = p.Module
+	}
 
 	// 通过 ssautil 获取所有函数
 	allfunc := ssautil.AllFunctions(program)
@@ -51,12 +80,28 @@ func ParseProject(propath string) (*Project, error) {
 	}
 
 	p.CheckOtherMember()
+	p.parseDirectives()
 	log.Println("finish the parsing project.")
 	return p, nil
 }
 
-// buildSSA 构建 ssa
-func buildSSA(projectPath string) (*ssa.Program, []*ssa.Package, error) {
+// 包中是否调用了 signal.Notify 或 signal.NotifyContext，即自己处理信号
+func handlesSignals(pkg *packages.Package) bool {
+	if pkg.TypesInfo == nil || pkg.PkgPath == "os/signal" {
+		return false
+	}
+	for _, obj := range pkg.TypesInfo.Uses {
+		if fn, ok := obj.(*types.Func); ok && fn.Pkg() != nil && fn.Pkg().Path() == "os/signal" &&
+			(fn.Name() == "Notify" || fn.Name() == "NotifyContext") {
+			return true
+		}
+	}
+	return false
+}
+
+// buildSSA 构建 ssa，同时返回加载的包
+// 包含测试时同一个包有带测试文件的变体，两者的同名函数视为同一个成员，见 FindFuncMember
+func buildSSA(projectPath string, tests bool) (*ssa.Program, []*ssa.Package, []*packages.Package, error) {
 	pkgs, _ := packages.Load(&packages.Config{
 		Mode: packages.NeedCompiledGoFiles |
 			packages.NeedDeps |
@@ -71,10 +116,10 @@ func buildSSA(projectPath string) (*ssa.Program, []*ssa.Package, error) {
 			packages.NeedTypes |
 			packages.NeedTypesInfo |
 			packages.NeedTypesSizes,
-		Tests: false,
+		Tests: tests,
 		Dir:   projectPath,
 		ParseFile: func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
-			if strings.HasSuffix(filename, "_test.go") {
+			if !tests && strings.HasSuffix(filename, "_test.go") {
 				return nil, nil
 			}
 			return parser.ParseFile(fset, filename, src, parser.ParseComments)
@@ -91,7 +136,7 @@ func buildSSA(projectPath string) (*ssa.Program, []*ssa.Package, error) {
 	}
 	log.Println(fmt.Sprintf("共有 %d 个包，正常解析有 %d 个", len(preSsaPkgs), len(ssaPkgs)))
 	if len(ssaPkgs) != len(preSsaPkgs) {
-		return nil, nil, errors.New("缺少有效的包，源码可能存在错误")
+		return nil, nil, nil, errors.New("缺少有效的包，源码可能存在错误")
 	}
-	return program, ssaPkgs, nil
+	return program, ssaPkgs, pkgs, nil
 }
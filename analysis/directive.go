@@ -0,0 +1,220 @@
+package analysis
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/Shanjm/tracing-aspect/log"
+)
+
+// 注释指令前缀，写作 //tracing:entry
+const DirectivePrefix = "tracing:"
+
+// 注释指令
+const (
+	DirectiveEntry  = "entry"  // 函数作为追踪入口
+	DirectiveTrace  = "trace"  // 记录函数的参数与返回值
+	DirectiveIgnore = "ignore" // 不插桩该函数；写在 package 之前时作用于文件，带 package 参数时作用于整个包
+	DirectiveRedact = "redact" // 参数脱敏，写在函数注释中时后跟参数名，不带参数名则全部脱敏；也可以写在参数后
+)
+
+// Directives 函数上的注释指令
+type Directives struct {
+	Entry  bool
+	Trace  bool
+	Ignore bool
+	Redact map[string]struct{} // 需要脱敏的参数名
+}
+
+// 解析后的单条指令
+type directive struct {
+	name string
+	args []string
+	pos  token.Pos
+}
+
+// 从注释中解析指令，支持 //tracing:x 与 /*tracing:x*/，// 后可以有空格
+func parseDirective(c *ast.Comment) (directive, bool) {
+	text := c.Text
+	if strings.HasPrefix(text, "/*") {
+		text = strings.TrimSuffix(text[2:], "*/")
+	} else {
+		text = strings.TrimPrefix(text, "//")
+	}
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, DirectivePrefix) {
+		return directive{}, false
+	}
+	fields := strings.Fields(strings.TrimPrefix(text, DirectivePrefix))
+	if len(fields) == 0 {
+		return directive{}, false
+	}
+	return directive{name: fields[0], args: fields[1:], pos: c.Pos()}, true
+}
+
+func directives(cg *ast.CommentGroup) []directive {
+	ds := []directive{}
+	if cg == nil {
+		return ds
+	}
+	for _, c := range cg.List {
+		if d, ok := parseDirective(c); ok {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// 读取各文件中的注释指令
+func (p *Project) parseDirectives() {
+	for _, pkg := range p.Pm {
+		for _, file := range pkg.Fm {
+			if file.ParsedFile != nil {
+				fset := pkg.Pkg.Prog.Fset
+				file.parseDirectives(fset, pkg)
+			}
+		}
+	}
+}
+
+func (f *File) parseDirectives(fset *token.FileSet, pkg *Package) {
+	af := f.ParsedFile
+
+	// package 之前的指令作用于文件或包，按行号记录其余注释组以便查找函数上方的注释
+	groups := make(map[int]*ast.CommentGroup)
+	for _, cg := range af.Comments {
+		if cg.End() < af.Package {
+			for _, d := range directives(cg) {
+				switch {
+				case d.name == DirectiveIgnore && len(d.args) > 0 && d.args[0] == "package":
+					pkg.Ignore = true
+				case d.name == DirectiveIgnore:
+					f.Ignore = true
+				default:
+					f.warn(fset, d, "only ignore is allowed before the package clause")
+				}
+			}
+			continue
+		}
+		groups[fset.Position(cg.End()).Line] = cg
+	}
+
+	ast.Inspect(af, func(n ast.Node) bool {
+		var doc *ast.CommentGroup
+		var ft *ast.FuncType
+		switch x := n.(type) {
+		case *ast.FuncDecl:
+			doc, ft = x.Doc, x.Type
+		case *ast.FuncLit:
+			ft = x.Type
+		default:
+			return true
+		}
+		m := f.memberAt(fset, n)
+		if m == nil {
+			return true
+		}
+		if doc == nil {
+			// 匿名函数使用紧邻上一行的注释
+			doc = groups[fset.Position(n.Pos()).Line-1]
+		}
+
+		for _, d := range directives(doc) {
+			switch d.name {
+			case DirectiveEntry:
+				m.Directives.Entry = true
+			case DirectiveTrace:
+				m.Directives.Trace = true
+			case DirectiveIgnore:
+				m.Directives.Ignore = true
+			case DirectiveRedact:
+				names := d.args
+				if len(names) == 0 {
+					names = paramNames(ft.Params.List)
+				}
+				for _, name := range names {
+					m.redact(name)
+				}
+			default:
+				f.warn(fset, d, "unknown directive")
+			}
+		}
+
+		// 写在参数后的 redact
+		for _, cg := range af.Comments {
+			if cg.Pos() <= ft.Params.Opening || cg.End() >= ft.Params.Closing {
+				continue
+			}
+			for _, d := range directives(cg) {
+				if d.name != DirectiveRedact {
+					f.warn(fset, d, "only redact is allowed in the parameter list")
+					continue
+				}
+				var field *ast.Field
+				for _, pf := range ft.Params.List {
+					if pf.Pos() < d.pos {
+						field = pf
+					}
+				}
+				if field == nil {
+					f.warn(fset, d, "no parameter before the directive")
+					continue
+				}
+				for _, name := range paramNames([]*ast.Field{field}) {
+					m.redact(name)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// 与节点位置相同的函数成员
+func (f *File) memberAt(fset *token.FileSet, n ast.Node) *Member {
+	pos, end := fset.Position(n.Pos()), fset.Position(n.End())
+	for _, m := range f.FunMember {
+		if m.Node != nil && m.ComparePostion(pos, end) {
+			return m
+		}
+	}
+	return nil
+}
+
+func (f *File) warn(fset *token.FileSet, d directive, msg string) {
+	log.Println(fmt.Sprintf("%s: %s%s: %s", fset.Position(d.pos), DirectivePrefix, d.name, msg))
+}
+
+func (m *Member) redact(name string) {
+	if m.Directives.Redact == nil {
+		m.Directives.Redact = make(map[string]struct{})
+	}
+	m.Directives.Redact[name] = struct{}{}
+}
+
+func paramNames(fields []*ast.Field) []string {
+	names := []string{}
+	for _, field := range fields {
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
+
+// Ignored 函数或其所在文件、包是否被 //tracing:ignore 排除
+func (p *Project) Ignored(m *Member) bool {
+	if m.Directives.Ignore {
+		return true
+	}
+	pkg, ok := p.Pm[m.Pkg.Pkg.Path()]
+	if !ok {
+		return false
+	}
+	if pkg.Ignore {
+		return true
+	}
+	file, ok := pkg.Fm[m.File]
+	return ok && file.Ignore
+}
@@ -20,18 +20,19 @@ const (
 type (
 	// 代码成员结构体
 	Member struct {
-		Name      string                     // 函数名，同 Fun.String()
-		Pkg       *ssa.Package               // 包
-		File      string                     // 文件名
-		Node      ast.Node                   // 包含了整体起始结束位置
-		Token     token.Token                // 函数则 String() 为 func
-		Type      types.Type                 // 函数则实际类型为 *types.Signature
-		Fun       *ssa.Function              // 函数
-		Wrapper   map[*ssa.Function]struct{} // 包装器
-		AnonFuncs []string                   // 成员内匿名函数名
-		ShortName string                     // 短名
-		NameNode  ast.Node                   // member name node or nil
-		ValueNode ast.Node                   // member value node or nil
+		Name       string                     // 函数名，同 Fun.String()
+		Pkg        *ssa.Package               // 包
+		File       string                     // 文件名
+		Node       ast.Node                   // 包含了整体起始结束位置
+		Token      token.Token                // 函数则 String() 为 func
+		Type       types.Type                 // 函数则实际类型为 *types.Signature
+		Fun        *ssa.Function              // 函数
+		Wrapper    map[*ssa.Function]struct{} // 包装器
+		AnonFuncs  []string                   // 成员内匿名函数名
+		ShortName  string                     // 短名
+		NameNode   ast.Node                   // member name node or nil
+		ValueNode  ast.Node                   // member value node or nil
+		Directives Directives                 // 函数上的注释指令
 	}
 
 	// 源码文件结构体
@@ -43,12 +44,14 @@ type (
 		File        string
 		ParsedFile  *ast.File
 		Imports     map[string]string
+		Ignore      bool // 文件被 //tracing:ignore 排除
 	}
 
 	// 源码包结构体
 	Package struct {
-		Fm  map[string]*File
-		Pkg *ssa.Package
+		Fm     map[string]*File
+		Pkg    *ssa.Package
+		Ignore bool // 包被 //tracing:ignore package 排除
 	}
 
 	// 项目结构体
@@ -56,8 +59,12 @@ type (
 		Pm         map[string]*Package
 		SsaProgram *ssa.Program
 		SsaPkgs    []*ssa.Package
-		RootPkg    string            // 包的根路径，目前看只有插桩时使用
-		Rely       map[string]string // key: 模块 value: 路径
+		RootPkg    string                         // 包的根路径，目前看只有插桩时使用
+		Rely       map[string]string              // key: 模块 value: 路径
+		Module     string                         // 模块路径
+		ModuleDir  string                         // 模块根目录
+		Info       map[*types.Package]*types.Info // 项目内各包的类型信息
+		Signals    []string                       // 模块中调用 os/signal 的 Notify 或 NotifyContext 的包
 
 		wrappers []*ssa.Function
 	}
@@ -327,6 +334,10 @@ func (p *Project) FindFuncMember(fun *ssa.Function) (*Member, bool) {
 					return mem, true
 				}
 			}
+			// 包含测试时，包的测试变体中的同名函数
+			if mem, ok := file.FunMember[fun.String()]; ok && fun.Synthetic == "" {
+				return mem, false
+			}
 		}
 	}
 
@@ -0,0 +1,26 @@
+// Package aspect 自定义通知使用的连接点，被插桩项目的通知包引用此包
+//
+// 通知包可以提供以下任意函数，插桩时织入选中的函数：
+//
+//	func Before(jp aspect.JoinPoint)
+//	func After(jp aspect.JoinPoint, results []interface{})
+//	func Around(jp aspect.JoinPoint, proceed func() []interface{}) []interface{}
+//
+// Around 需调用 proceed 执行原函数，其返回值作为原函数的返回值
+package aspect
+
+// JoinPoint 连接点，即一次函数调用
+type JoinPoint struct {
+	Func     string        // 函数名，同 analysis.Member.Name
+	Receiver interface{}   // 方法接收者，函数为 nil
+	Args     []interface{} // 参数
+	Pos      string        // 函数定义位置，相对模块根目录的 文件:行号
+}
+
+// Result 返回第 i 个结果，越界时返回 nil，供织入代码做类型断言
+func Result(results []interface{}, i int) interface{} {
+	if i < 0 || i >= len(results) {
+		return nil
+	}
+	return results[i]
+}
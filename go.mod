@@ -3,6 +3,7 @@ module github.com/Shanjm/tracing-aspect
 go 1.16
 
 require (
+	github.com/dave/dst v0.27.3
 	github.com/petermattis/goid v0.0.0-20230518223814-80aa455d8761
 	golang.org/x/tools v0.10.0
 	golang.org/x/tools/go/pointer v0.1.0-deprecated
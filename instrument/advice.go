@@ -0,0 +1,254 @@
+package instrument
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+)
+
+// 连接点所在的包
+const aspectPkg = "github.com/Shanjm/tracing-aspect/aspect"
+
+// 通知包中找到的通知函数
+type advice struct {
+	name   string // 包名
+	before bool
+	after  bool
+	around bool
+}
+
+// 加载通知包，检查 Before/After/Around 的签名
+func (i *InsPara) loadAdvice() error {
+	if i.Advice == "" {
+		return nil
+	}
+	var pkg *types.Package
+	for _, p := range i.Project.SsaProgram.AllPackages() {
+		if p.Pkg.Path() == i.Advice {
+			pkg = p.Pkg
+			break
+		}
+	}
+	if pkg == nil {
+		return fmt.Errorf("advice package %s is not part of the project", i.Advice)
+	}
+
+	slice := types.NewSlice(types.NewInterfaceType(nil, nil).Complete())
+	proceed := types.NewSignature(nil, nil, types.NewTuple(types.NewVar(token.NoPos, nil, "", slice)), false)
+	wants := []struct {
+		name    string
+		params  []types.Type // nil 为 JoinPoint
+		results []types.Type
+		found   *bool
+	}{
+		{"Before", []types.Type{nil}, nil, new(bool)},
+		{"After", []types.Type{nil, slice}, nil, new(bool)},
+		{"Around", []types.Type{nil, proceed}, []types.Type{slice}, new(bool)},
+	}
+
+	a := &advice{name: pkg.Name()}
+	for _, w := range wants {
+		fn, ok := pkg.Scope().Lookup(w.name).(*types.Func)
+		if !ok {
+			continue
+		}
+		sig := fn.Type().(*types.Signature)
+		if !matchSignature(sig, w.params, w.results) {
+			return fmt.Errorf("%s.%s has signature %s, see package aspect for the expected one", i.Advice, w.name, sig)
+		}
+		*w.found = true
+	}
+	a.before, a.after, a.around = *wants[0].found, *wants[1].found, *wants[2].found
+	if !a.before && !a.after && !a.around {
+		return fmt.Errorf("advice package %s has none of Before, After and Around", i.Advice)
+	}
+	i.advice = a
+	return nil
+}
+
+func matchSignature(sig *types.Signature, params, results []types.Type) bool {
+	if sig.Variadic() || sig.Params().Len() != len(params) || sig.Results().Len() != len(results) {
+		return false
+	}
+	for idx, t := range params {
+		pt := sig.Params().At(idx).Type()
+		if t == nil {
+			named, ok := pt.(*types.Named)
+			if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != aspectPkg || named.Obj().Name() != "JoinPoint" {
+				return false
+			}
+			continue
+		}
+		if !types.Identical(pt, t) {
+			return false
+		}
+	}
+	for idx, t := range results {
+		if !types.Identical(sig.Results().At(idx).Type(), t) {
+			return false
+		}
+	}
+	return true
+}
+
+// 是否为不能插桩的包：运行时包、通知包与连接点包
+func (i *InsPara) isAspectPkg(pkgPath string) bool {
+	return pkgPath == i.runtimePkg() || pkgPath == i.Advice || pkgPath == aspectPkg
+}
+
+// 织入通知，插桩插入的开头语句之后的原函数体包装为闭包，协程注册与调用记录等不受通知影响：
+//
+//	_jp := aspect.JoinPoint{...}
+//	advice.Before(_jp)
+//	_results := advice.Around(_jp, func() []interface{} {
+//		_r0, _r1 = func() (T0, T1) { 原函数体 }()
+//		return []interface{}{_r0, _r1}
+//	})
+//	_r0, _ = aspect.Result(_results, 0).(T0)
+//	advice.After(_jp, []interface{}{_r0, _r1})
+//	return _r0, _r1
+func (i *InsPara) weaveAdvice(funcMember *analysis.Member, recv *ast.FieldList, ft *ast.FuncType, body *ast.BlockStmt) {
+	a := i.advice
+	jp := &ast.CompositeLit{
+		Type: &ast.SelectorExpr{X: i.pkgIdent(aspectPkg, "aspect"), Sel: ast.NewIdent("JoinPoint")},
+		Elts: []ast.Expr{
+			&ast.KeyValueExpr{Key: ast.NewIdent("Func"), Value: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(funcMember.Name)}},
+		},
+	}
+	if recvs := i.nameFields(funcMember, recv, "recv"); len(recvs) > 0 {
+		jp.Elts = append(jp.Elts, &ast.KeyValueExpr{Key: ast.NewIdent("Receiver"), Value: recvs[0]})
+	}
+	if args := redactArgs(funcMember, i.nameFields(funcMember, ft.Params, "arg")); len(args) > 0 {
+		jp.Elts = append(jp.Elts, &ast.KeyValueExpr{Key: ast.NewIdent("Args"), Value: interfaceSlice(args)})
+	}
+	jp.Elts = append(jp.Elts, &ast.KeyValueExpr{Key: ast.NewIdent("Pos"), Value: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(i.joinPointPos(funcMember))}})
+
+	jpVar := i.varName(funcMember, "jp")
+	stmts := []ast.Stmt{
+		&ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent(jpVar)}, Tok: token.DEFINE, Rhs: []ast.Expr{jp}},
+	}
+	if a.before {
+		stmts = append(stmts, i.adviceCall(jpVar, "Before"))
+	}
+	// 开头插入的语句留在外层
+	head, rest := body.List[:i.preamble[body]:i.preamble[body]], body.List[i.preamble[body]:]
+	if !a.after && !a.around {
+		body.List = append(head, append(stmts, rest...)...)
+		return
+	}
+
+	// 原返回值（含名字）移到闭包上，外层沿用原名，开头插入的 defer 仍可读取，匿名的命名为 _r0...
+	inner := &ast.FieldList{}
+	results := []ast.Expr{}
+	if ft.Results != nil {
+		outer := &ast.FieldList{}
+		index := 0
+		for _, field := range ft.Results.List {
+			inner.List = append(inner.List, &ast.Field{Names: field.Names, Type: field.Type})
+			n := len(field.Names)
+			if n == 0 {
+				n = 1
+			}
+			for k := 0; k < n; k++ {
+				var name string
+				if k < len(field.Names) && field.Names[k].Name != "_" {
+					name = field.Names[k].Name
+				} else {
+					name = i.varName(funcMember, "r%d", index)
+				}
+				outer.List = append(outer.List, &ast.Field{Names: []*ast.Ident{ast.NewIdent(name)}, Type: field.Type})
+				results = append(results, ast.NewIdent(name))
+				index++
+			}
+		}
+		ft.Results = outer
+	}
+
+	// 执行原函数体
+	var call ast.Stmt = &ast.ExprStmt{
+		X: &ast.CallExpr{Fun: &ast.FuncLit{Type: &ast.FuncType{Params: &ast.FieldList{}, Results: inner}, Body: &ast.BlockStmt{List: rest}}},
+	}
+	if len(results) > 0 {
+		call = &ast.AssignStmt{Lhs: results, Tok: token.ASSIGN, Rhs: []ast.Expr{call.(*ast.ExprStmt).X}}
+	}
+
+	if a.around {
+		proceed := &ast.FuncLit{
+			Type: &ast.FuncType{Params: &ast.FieldList{}, Results: &ast.FieldList{List: []*ast.Field{{Type: emptyInterfaceSlice()}}}},
+			Body: &ast.BlockStmt{List: []ast.Stmt{call, &ast.ReturnStmt{Results: []ast.Expr{resultSlice(results)}}}},
+		}
+		around := i.adviceCall(jpVar, "Around", proceed).(*ast.ExprStmt).X
+		if len(results) == 0 {
+			stmts = append(stmts, &ast.ExprStmt{X: around})
+		} else {
+			resultsVar := i.varName(funcMember, "results")
+			stmts = append(stmts, &ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent(resultsVar)}, Tok: token.DEFINE, Rhs: []ast.Expr{around}})
+			// 取回 Around 的返回值，类型不符时为零值
+			for idx, field := range ft.Results.List {
+				stmts = append(stmts, &ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent(field.Names[0].Name), ast.NewIdent("_")},
+					Tok: token.ASSIGN,
+					Rhs: []ast.Expr{&ast.TypeAssertExpr{
+						X: &ast.CallExpr{
+							Fun:  &ast.SelectorExpr{X: i.pkgIdent(aspectPkg, "aspect"), Sel: ast.NewIdent("Result")},
+							Args: []ast.Expr{ast.NewIdent(resultsVar), &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(idx)}},
+						},
+						Type: field.Type,
+					}},
+				})
+			}
+		}
+	} else {
+		stmts = append(stmts, call)
+	}
+
+	if a.after {
+		stmts = append(stmts, i.adviceCall(jpVar, "After", resultSlice(results)))
+	}
+	if len(results) > 0 {
+		stmts = append(stmts, &ast.ReturnStmt{Results: results})
+	}
+	body.List = append(head, stmts...)
+}
+
+// 调用通知函数，第一个参数为连接点变量 jp
+func (i *InsPara) adviceCall(jp, name string, args ...ast.Expr) ast.Stmt {
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun:  &ast.SelectorExpr{X: i.pkgIdent(i.Advice, i.advice.name), Sel: ast.NewIdent(name)},
+			Args: append([]ast.Expr{ast.NewIdent(jp)}, args...),
+		},
+	}
+}
+
+// 连接点位置，相对模块根目录
+func (i *InsPara) joinPointPos(funcMember *analysis.Member) string {
+	pos := funcMember.Fun.Prog.Fset.Position(funcMember.Fun.Pos())
+	if rel, err := filepath.Rel(i.Project.ModuleDir, pos.Filename); err == nil {
+		pos.Filename = filepath.ToSlash(rel)
+	}
+	return fmt.Sprintf("%s:%d", pos.Filename, pos.Line)
+}
+
+// []interface{}
+func emptyInterfaceSlice() ast.Expr {
+	return &ast.ArrayType{Elt: &ast.InterfaceType{Methods: &ast.FieldList{}}}
+}
+
+// []interface{}{args...}
+func interfaceSlice(args []ast.Expr) ast.Expr {
+	return &ast.CompositeLit{Type: emptyInterfaceSlice(), Elts: args}
+}
+
+// 结果列表，无结果时为 nil
+func resultSlice(results []ast.Expr) ast.Expr {
+	if len(results) == 0 {
+		return ast.NewIdent("nil")
+	}
+	return interfaceSlice(results)
+}
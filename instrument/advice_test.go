@@ -0,0 +1,201 @@
+package instrument
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shanjm/tracing-aspect/instrument/goreport"
+	"github.com/Shanjm/tracing-aspect/selector"
+)
+
+// 具名、匿名、空白返回值与无返回值的函数
+const adviceMain = `package main
+
+import "fmt"
+
+func named(n int) (sum int, err error) {
+	sum = n + 1
+	return
+}
+
+func unnamed(n int) (int, string) { return n + 1, "u" }
+
+func blank(n int) (_ int, _ error) { return n + 1, nil }
+
+func none(n int) { fmt.Println("none", n) }
+
+func main() {
+	s, err := named(1)
+	fmt.Println("named", s, err)
+	a, b := unnamed(1)
+	fmt.Println("unnamed", a, b)
+	c, e := blank(1)
+	fmt.Println("blank", c, e)
+	none(1)
+}
+`
+
+// 通知函数，Around 将第一个 int 结果乘以 10
+var adviceFuncs = map[string]string{
+	"Before": `func Before(jp aspect.JoinPoint) { fmt.Println("before", jp.Func, jp.Args) }`,
+	"After":  `func After(jp aspect.JoinPoint, results []interface{}) { fmt.Println("after", jp.Func, results) }`,
+	"Around": `func Around(jp aspect.JoinPoint, proceed func() []interface{}) []interface{} {
+	results := proceed()
+	if n, ok := aspect.Result(results, 0).(int); ok {
+		results[0] = n * 10
+	}
+	return results
+}`,
+}
+
+func TestWeaveAdvice(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the instrumented program")
+	}
+	aspectSrc, err := os.ReadFile(filepath.Join("..", "aspect", "aspect.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		advice  []string
+		stdout  string
+		results map[string]string // 函数 span 记录的返回值
+	}{
+		{
+			name:   "before",
+			advice: []string{"Before"},
+			stdout: `before example.com/app.named [1]
+named 2 <nil>
+before example.com/app.unnamed [1]
+unnamed 2 u
+before example.com/app.blank [1]
+blank 2 <nil>
+before example.com/app.none [1]
+none 1
+`,
+			results: map[string]string{"named": "2,nil", "unnamed": "2,u", "blank": "2,nil"},
+		},
+		{
+			name:   "after",
+			advice: []string{"After"},
+			stdout: `after example.com/app.named [2 <nil>]
+named 2 <nil>
+after example.com/app.unnamed [2 u]
+unnamed 2 u
+after example.com/app.blank [2 <nil>]
+blank 2 <nil>
+none 1
+after example.com/app.none []
+`,
+			results: map[string]string{"named": "2,nil", "unnamed": "2,u", "blank": "2,nil"},
+		},
+		{
+			name:   "around replaces the results",
+			advice: []string{"Around"},
+			stdout: `named 20 <nil>
+unnamed 20 u
+blank 20 <nil>
+none 1
+`,
+			results: map[string]string{"named": "20,nil", "unnamed": "20,u", "blank": "20,nil"},
+		},
+		{
+			name:   "all",
+			advice: []string{"Before", "Around", "After"},
+			stdout: `before example.com/app.named [1]
+after example.com/app.named [20 <nil>]
+named 20 <nil>
+before example.com/app.unnamed [1]
+after example.com/app.unnamed [20 u]
+unnamed 20 u
+before example.com/app.blank [1]
+after example.com/app.blank [20 <nil>]
+blank 20 <nil>
+before example.com/app.none [1]
+none 1
+after example.com/app.none []
+`,
+			results: map[string]string{"named": "20,nil", "unnamed": "20,u", "blank": "20,nil"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			funcs := []string{}
+			for _, name := range tt.advice {
+				funcs = append(funcs, adviceFuncs[name])
+			}
+			root := writeModule(t, map[string]string{
+				"go.mod":                          testGoMod + "\nrequire github.com/Shanjm/tracing-aspect v0.0.0\n\nreplace github.com/Shanjm/tracing-aspect => ./tracing-aspect\n",
+				"tracing-aspect/go.mod":           "module github.com/Shanjm/tracing-aspect\n\ngo 1.16\n",
+				"tracing-aspect/aspect/aspect.go": string(aspectSrc),
+				"main.go":                         adviceMain,
+				"advice/advice.go": "package advice\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/Shanjm/tracing-aspect/aspect\"\n)\n\nvar _ = fmt.Println\n\n" +
+					strings.Join(funcs, "\n\n") + "\n",
+			})
+			out, err := instrumentTree(t, root, func(i *InsPara) {
+				i.Detectors = []EntryDetector{mainDetector{}}
+				i.Rules = []selector.Rule{{Package: "example.com/app", Func: "^(named|unnamed|blank|none)$"}}
+				i.Advice = "example.com/app/advice"
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			traces := filepath.Join(t.TempDir(), "traces.jsonl")
+			cmd := exec.Command("go", "run", ".")
+			cmd.Dir = out
+			cmd.Env = append(os.Environ(), "TRACING_EXPORTER=jsonl", "TRACING_EXPORT_FILE="+traces)
+			stdout, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("%v: %s", err, err.(*exec.ExitError).Stderr)
+			}
+			if string(stdout) != tt.stdout {
+				t.Errorf("got output\n%s\nwant\n%s", stdout, tt.stdout)
+			}
+
+			got := spanResults(t, traces)
+			for name, want := range tt.results {
+				if got[name] != want {
+					t.Errorf("%s records results %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+// 导出文件中各函数 span 记录的返回值，key 为不含包名的函数名
+func spanResults(t *testing.T, path string) map[string]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	results := map[string]string{}
+	var walk func(s *goreport.Span)
+	walk = func(s *goreport.Span) {
+		if s.Kind == goreport.KindFunc {
+			results[strings.TrimPrefix(s.Name, "example.com/app.")] = strings.Join(s.Results, ",")
+		}
+		for _, c := range s.Children {
+			walk(c)
+		}
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		root := &goreport.Span{}
+		if err := json.Unmarshal(scanner.Bytes(), root); err != nil {
+			t.Fatal(err)
+		}
+		walk(root)
+	}
+	return results
+}
@@ -0,0 +1,116 @@
+package instrument
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/ssa"
+)
+
+// 被替换的调用
+type callSite struct {
+	wrapper string // 运行时包装函数名
+	recv    bool   // 以接收者而非方法值作为第一个参数，用于按接收者查找信息的 *sql.Stmt
+}
+
+// 需要替换的调用，key 为被调函数的 ssa 名
+var callSites = func() map[string]callSite {
+	sites := map[string]callSite{}
+	// 出站 http 调用
+	for _, name := range []string{"Get", "Head", "Post", "PostForm"} {
+		sites["net/http."+name] = callSite{wrapper: "Client" + name}
+		sites["(*net/http.Client)."+name] = callSite{wrapper: "Client" + name}
+	}
+	sites["(*net/http.Client).Do"] = callSite{wrapper: "ClientDo"}
+
+	// sql 查询，Conn 只有 Context 形式；预编译语句的 sql 由运行时从语句中读取
+	for _, name := range []string{"Query", "Exec", "QueryRow"} {
+		for _, recv := range []string{"DB", "Tx"} {
+			sites["(*database/sql."+recv+")."+name] = callSite{wrapper: "SQL" + name}
+		}
+		for _, recv := range []string{"DB", "Tx", "Conn"} {
+			sites["(*database/sql."+recv+")."+name+"Context"] = callSite{wrapper: "SQL" + name + "Context"}
+		}
+	}
+	for _, name := range []string{"Query", "QueryContext", "Exec", "ExecContext", "QueryRow", "QueryRowContext"} {
+		sites["(*database/sql.Stmt)."+name] = callSite{wrapper: "Stmt" + name, recv: true}
+	}
+	return sites
+}()
+
+// 调用需要替换为的运行时函数
+func callWrapper(c *ssa.CallCommon) (callSite, bool) {
+	fn := c.StaticCallee()
+	if fn == nil {
+		return callSite{}, false
+	}
+	site, ok := callSites[fn.String()]
+	if ok && site.recv {
+		// 接收者是取址得到的变量时，语法树中不是指针，不能作为参数
+		switch c.Args[0].(type) {
+		case *ssa.Alloc, *ssa.FieldAddr, *ssa.IndexAddr:
+			return callSite{}, false
+		}
+	}
+	return site, ok
+}
+
+// 将函数中的调用 f(args) 替换为 rt.Wrapper(f, args)，包装函数调用 f 并记录；
+// recv 为 true 时 x.f(args) 替换为 rt.Wrapper(x, args)
+// 调用点由 ssa 找出，按左括号的行列号对应到改写中的语法树
+func (i *InsPara) wrapCalls(funcMember *analysis.Member, body *ast.BlockStmt) {
+	fset := funcMember.Fun.Prog.Fset
+	type lineCol struct{ line, col int }
+	sites := make(map[lineCol]callSite)
+
+	var collect func(fn *ssa.Function)
+	collect = func(fn *ssa.Function) {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				// go 语句另有改写，不替换
+				call, ok := instr.(ssa.CallInstruction)
+				if _, isGo := instr.(*ssa.Go); !ok || isGo || !call.Common().Pos().IsValid() {
+					continue
+				}
+				if site, ok := callWrapper(call.Common()); ok {
+					pos := fset.Position(call.Common().Pos())
+					sites[lineCol{pos.Line, pos.Column}] = site
+				}
+			}
+		}
+		for _, anon := range fn.AnonFuncs {
+			collect(anon)
+		}
+	}
+	collect(funcMember.Fun)
+	if len(sites) == 0 {
+		return
+	}
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || !call.Lparen.IsValid() {
+			return true
+		}
+		pos := fset.Position(call.Lparen)
+		site, ok := sites[lineCol{pos.Line, pos.Column}]
+		if !ok {
+			return true
+		}
+		fun := call.Fun
+		if site.recv {
+			sel, ok := astutil.Unparen(call.Fun).(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			fun = sel.X
+		}
+		args := append([]ast.Expr{fun}, call.Args...)
+		call.Fun = &ast.SelectorExpr{X: i.runtimeIdent(), Sel: ast.NewIdent(site.wrapper)}
+		call.Args = args
+		call.Lparen = token.NoPos
+		return true
+	})
+}
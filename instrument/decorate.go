@@ -0,0 +1,140 @@
+package instrument
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"reflect"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+
+	"github.com/Shanjm/tracing-aspect/log"
+)
+
+// 插入的节点没有位置，直接打印时 printer 按输出长度估算位置，注释会错位到插入的代码中间。
+// 改写前用 dst 记下每个源码节点上的注释与空行，输出时先不带注释打印改写后的文件并重新解析，
+// 两棵树结构相同，按遍历顺序把记下的装饰放回对应的节点，再由 dst 打印
+
+// 改写前记录文件的装饰
+func decorate(fset *token.FileSet, file *ast.File) *decorator.Decorator {
+	dec := decorator.NewDecorator(fset)
+	if _, err := dec.DecorateFile(file); err != nil {
+		log.Println(fmt.Sprintf("decorate %s: %v", fset.Position(file.Pos()).Filename, err))
+		return nil
+	}
+	return dec
+}
+
+// 打印改写后的文件，dec 为改写前记录的装饰，为 nil 时直接打印
+func printFile(fset *token.FileSet, file *ast.File, dec *decorator.Decorator) ([]byte, error) {
+	buffer := bytes.NewBufferString("")
+	if dec == nil {
+		if err := format.Node(buffer, fset, file); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	}
+
+	// format.Node 会在副本上排序导入，先排好使两棵树的顺序一致；
+	// Comments 不为 nil 时 printer 不再输出节点上的 Doc 与 Comment
+	ast.SortImports(fset, file)
+	bare := *file
+	bare.Comments = []*ast.CommentGroup{}
+	if err := format.Node(buffer, fset, &bare); err != nil {
+		return nil, err
+	}
+	refset := token.NewFileSet()
+	reparsed, err := parser.ParseFile(refset, fset.Position(file.Pos()).Filename, buffer.Bytes(), 0)
+	if err != nil {
+		return nil, err
+	}
+	redec := decorator.NewDecorator(refset)
+	df, err := redec.DecorateFile(reparsed)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, renodes := preorder(file), preorder(reparsed)
+	if len(nodes) != len(renodes) {
+		return nil, fmt.Errorf("reparsed file has %d nodes, want %d", len(renodes), len(nodes))
+	}
+	restored := make(map[ast.Node]struct{})
+	for idx, n := range nodes {
+		if reflect.TypeOf(n) != reflect.TypeOf(renodes[idx]) {
+			return nil, fmt.Errorf("reparsed file has %T at %T", renodes[idx], n)
+		}
+		if _, ok := restored[n]; ok {
+			// 插入代码中复用的源码节点，装饰只放回第一次出现的位置
+			continue
+		}
+		restored[n] = struct{}{}
+		to := redec.Dst.Nodes[renodes[idx]]
+		if from, ok := dec.Dst.Nodes[n]; ok {
+			copyDecs(to, from)
+		} else if to != nil {
+			trimDecs(to)
+		}
+	}
+
+	buffer.Reset()
+	if err := decorator.NewRestorer().Fprint(buffer, df); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// 前序遍历的节点，不含注释；函数签名中空的参数与结果列表在生成的代码与解析结果中可能一个为 nil，也不含
+func preorder(file *ast.File) []ast.Node {
+	nodes := []ast.Node{}
+	signature := make(map[*ast.FieldList]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case nil, *ast.CommentGroup, *ast.Comment:
+			return false
+		case *ast.FuncType:
+			signature[x.TypeParams], signature[x.Params], signature[x.Results] = true, true, true
+		case *ast.FieldList:
+			if signature[x] && len(x.List) == 0 {
+				return false
+			}
+		}
+		nodes = append(nodes, n)
+		return true
+	})
+	return nodes
+}
+
+// 插入的节点不保留中间结果里的空行与换行，如没有位置的 interface{} 会被打印成两行
+func trimDecs(n dst.Node) {
+	decs := n.Decorations()
+	if decs.Before == dst.EmptyLine {
+		decs.Before = dst.NewLine
+	}
+	if decs.After == dst.EmptyLine {
+		decs.After = dst.NewLine
+	}
+	v := reflect.ValueOf(n).Elem().FieldByName("Decs")
+	for idx := 0; idx < v.NumField(); idx++ {
+		if f, ok := v.Field(idx).Addr().Interface().(*dst.Decorations); ok {
+			kept := dst.Decorations{}
+			for _, d := range *f {
+				if d != "\n" {
+					kept = append(kept, d)
+				}
+			}
+			*f = kept
+		}
+	}
+}
+
+// 复制同类型 dst 节点的 Decs 字段
+func copyDecs(to, from dst.Node) {
+	if to == nil || reflect.TypeOf(to) != reflect.TypeOf(from) {
+		return
+	}
+	reflect.ValueOf(to).Elem().FieldByName("Decs").Set(reflect.ValueOf(from).Elem().FieldByName("Decs"))
+}
@@ -0,0 +1,76 @@
+package instrument
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestPrintFileKeepsComments(t *testing.T) {
+	const src = `package p
+
+type E struct {
+}
+
+// F doc
+func F(a int) int {
+	// guard
+	if a == 0 { // zero
+		return 0 // none
+	}
+
+	// result
+	return a + 1
+}
+`
+	const want = `package p
+
+type E struct {
+}
+
+// F doc
+func F(a int) int {
+	defer trace(a, []interface{}{})()
+	// guard
+	if a == 0 { // zero
+		return func() (_r int) {
+			_r = 0
+			return
+		}() // none
+	}
+
+	// result
+	return a + 1
+}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := decorate(fset, file)
+	if dec == nil {
+		t.Fatal("decorate failed")
+	}
+
+	body := file.Decls[1].(*ast.FuncDecl).Body
+	stmt := &ast.DeferStmt{Call: &ast.CallExpr{Fun: &ast.CallExpr{Fun: ast.NewIdent("trace"), Args: []ast.Expr{ast.NewIdent("a"), interfaceSlice(nil)}}}}
+	body.List = append([]ast.Stmt{stmt}, body.List...)
+	ret := body.List[1].(*ast.IfStmt).Body.List[0].(*ast.ReturnStmt)
+	ret.Results = []ast.Expr{&ast.CallExpr{Fun: &ast.FuncLit{
+		Type: &ast.FuncType{Results: &ast.FieldList{List: []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("_r")}, Type: ast.NewIdent("int")}}}},
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent("_r")}, Tok: token.ASSIGN, Rhs: ret.Results},
+			&ast.ReturnStmt{},
+		}},
+	}}}
+
+	got, err := printFile(fset, file, dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("printFile:\n%s\nwant:\n%s", got, want)
+	}
+}
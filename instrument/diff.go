@@ -0,0 +1,224 @@
+package instrument
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 统一 diff 的上下文行数
+const diffContext = 3
+
+// 单行编辑操作
+type edit struct {
+	op   byte // ' ' 不变，'-' 删除，'+' 新增
+	line string
+}
+
+// 输出每个改写文件的统一 diff，不写任何文件
+func (i *InsPara) writeDiff(srcs map[string][]byte) error {
+	w := i.DiffOutput
+	if w == nil {
+		w = os.Stdout
+	}
+	modRoot, err := moduleRoot(i.RootDir)
+	if err != nil {
+		return err
+	}
+
+	filenames := make([]string, 0, len(srcs))
+	for filename := range srcs {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		rel, err := filepath.Rel(modRoot, filename)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		oldName := "a/" + rel
+		ori, err := os.ReadFile(filename)
+		if os.IsNotExist(err) {
+			// 新建的文件，如运行时包
+			oldName, err = "/dev/null", nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := unifiedDiff(w, oldName, "b/"+rel, ori, srcs[filename]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 生成统一 diff，内容相同时不输出
+func unifiedDiff(w io.Writer, oldName, newName string, oldSrc, newSrc []byte) error {
+	if bytes.Equal(oldSrc, newSrc) {
+		return nil
+	}
+	edits := diffLines(splitLines(oldSrc), splitLines(newSrc))
+
+	buffer := bytes.NewBufferString("")
+	fmt.Fprintf(buffer, "--- %s\n+++ %s\n", oldName, newName)
+
+	for start := 0; start < len(edits); {
+		// 找到下一处改动
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+
+		// 向后合并相距不超过 2*diffContext 的改动
+		end := start
+		for j := start; j < len(edits); j++ {
+			if edits[j].op != ' ' {
+				end = j + 1
+				continue
+			}
+			if j-end >= 2*diffContext {
+				break
+			}
+		}
+
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		oldLine, newLine := 1, 1
+		for _, e := range edits[:from] {
+			if e.op != '+' {
+				oldLine++
+			}
+			if e.op != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				oldCount++
+			}
+			if e.op != '-' {
+				newCount++
+			}
+		}
+		if oldCount == 0 {
+			oldLine--
+		}
+		if newCount == 0 {
+			newLine--
+		}
+
+		fmt.Fprintf(buffer, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+		for _, e := range edits[from:to] {
+			fmt.Fprintf(buffer, "%c%s", e.op, e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				buffer.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// 按行切分，保留换行符，末尾没有换行的行与有换行的同一行不同
+func splitLines(src []byte) []string {
+	if len(src) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(src), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Myers 差分算法，返回将 a 变为 b 的编辑序列
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, offset)
+			}
+		}
+	}
+	return nil
+}
+
+// 根据每轮快照回溯出编辑序列
+func backtrack(a, b []string, trace [][]int, offset int) []edit {
+	x, y := len(a), len(b)
+	edits := []edit{}
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{op: ' ', line: a[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				edits = append(edits, edit{op: '+', line: b[y]})
+			} else {
+				x--
+				edits = append(edits, edit{op: '-', line: a[x]})
+			}
+		}
+	}
+
+	for l, r := 0, len(edits)-1; l < r; l, r = l+1, r-1 {
+		edits[l], edits[r] = edits[r], edits[l]
+	}
+	return edits
+}
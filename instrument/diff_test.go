@@ -0,0 +1,100 @@
+package instrument
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// n 行 "line1\n" ... "linen\n"，replace 中的行号替换为对应内容，内容为空时删除该行
+func numbered(n int, replace map[int]string) string {
+	b := &strings.Builder{}
+	for idx := 1; idx <= n; idx++ {
+		line, ok := replace[idx]
+		if !ok {
+			line = fmt.Sprintf("line%d\n", idx)
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{name: "same", old: "a\nb\n", new: "a\nb\n", want: ""},
+		{
+			name: "empty to non-empty",
+			old:  "", new: "a\nb\n",
+			want: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "non-empty to empty",
+			old:  "a\nb\n", new: "",
+			want: "@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "change in the middle",
+			old:  numbered(10, nil), new: numbered(10, map[int]string{5: "five\n"}),
+			want: "@@ -2,7 +2,7 @@\n line2\n line3\n line4\n-line5\n+five\n line6\n line7\n line8\n",
+		},
+		{
+			name: "insert at the start",
+			old:  numbered(5, nil), new: "line0\n" + numbered(5, nil),
+			want: "@@ -1,3 +1,4 @@\n+line0\n line1\n line2\n line3\n",
+		},
+		{
+			name: "delete at the end",
+			old:  numbered(5, nil), new: numbered(5, map[int]string{5: ""}),
+			want: "@@ -2,4 +2,3 @@\n line2\n line3\n line4\n-line5\n",
+		},
+		{
+			// 两处改动之间 6 行不变，合并为一个块
+			name: "adjacent hunks",
+			old:  numbered(20, nil), new: numbered(20, map[int]string{5: "five\n", 12: "twelve\n"}),
+			want: "@@ -2,14 +2,14 @@\n line2\n line3\n line4\n-line5\n+five\n" +
+				" line6\n line7\n line8\n line9\n line10\n line11\n-line12\n+twelve\n line13\n line14\n line15\n",
+		},
+		{
+			// 之间 7 行不变，分为两个块
+			name: "separate hunks",
+			old:  numbered(20, nil), new: numbered(20, map[int]string{5: "five\n", 13: "thirteen\n"}),
+			want: "@@ -2,7 +2,7 @@\n line2\n line3\n line4\n-line5\n+five\n line6\n line7\n line8\n" +
+				"@@ -10,7 +10,7 @@\n line10\n line11\n line12\n-line13\n+thirteen\n line14\n line15\n line16\n",
+		},
+		{
+			name: "newline added at the end",
+			old:  "a\nb", new: "a\nb\n",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "newline removed at the end",
+			old:  "a\nb\n", new: "a\nc",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name: "both without newline",
+			old:  "a\nb", new: "x\nb",
+			want: "@@ -1,2 +1,2 @@\n-a\n+x\n b\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &bytes.Buffer{}
+			if err := unifiedDiff(b, "a/f.go", "b/f.go", []byte(tt.old), []byte(tt.new)); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want != "" {
+				want = "--- a/f.go\n+++ b/f.go\n" + want
+			}
+			if b.String() != want {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+			}
+		})
+	}
+}
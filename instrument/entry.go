@@ -0,0 +1,349 @@
+package instrument
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+	"golang.org/x/tools/go/ssa"
+)
+
+// 内置入口识别策略的名字
+const (
+	EntryHTTP      = "http"      // net/http 处理函数，最后两个参数为 http.ResponseWriter 与 *http.Request
+	EntryGRPC      = "grpc"      // 实现生成的 XxxServer 接口的方法
+	EntryMain      = "main"      // main.main
+	EntryInit      = "init"      // main 包的 init 函数，与 main.main 同属进程级的 trace
+	EntryTest      = "test"      // _test.go 中的 TestXxx 与 BenchmarkXxx，需要 Tests
+	EntryConfig    = "config"    // 函数名匹配 Entries 中的正则
+	EntryDirective = "directive" // 带 //tracing:entry 的函数
+	EntryAll       = "all"       // 所有函数
+)
+
+// EntryDetector 入口识别策略，决定哪些函数调用 StartMultiMode 以及入口处插入的采集代码
+type EntryDetector interface {
+	Name() string
+	// Detect 函数是否为入口
+	Detect(i *InsPara, m *analysis.Member) bool
+	// Capture 入口处插入的请求与响应采集语句，位于 StartMultiMode 之后，ft 为改写中的函数类型
+	Capture(i *InsPara, m *analysis.Member, ft *ast.FuncType) []ast.Stmt
+}
+
+// RuntimeCall 构造对运行时包函数的调用，供自定义策略生成采集代码
+func (i *InsPara) RuntimeCall(name string, args ...ast.Expr) *ast.CallExpr {
+	return &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: i.runtimeIdent(), Sel: ast.NewIdent(name)},
+		Args: args,
+	}
+}
+
+// ParseEntryModes 按名字返回内置策略，config 使用 entries 中的正则；entries 不为空时总是包含 config
+func ParseEntryModes(modes []string, entries []string) ([]EntryDetector, error) {
+	detectors := []EntryDetector{}
+	hasConfig := false
+	for _, mode := range modes {
+		var d EntryDetector
+		switch mode = strings.TrimSpace(mode); mode {
+		case EntryHTTP:
+			d = httpDetector{}
+		case EntryGRPC:
+			d = grpcDetector{}
+		case EntryMain:
+			d = mainDetector{}
+		case EntryInit:
+			d = initDetector{}
+		case EntryTest:
+			d = testDetector{}
+		case EntryDirective:
+			d = directiveDetector{}
+		case EntryAll:
+			d = allDetector{}
+		case EntryConfig:
+			cd, err := newConfigDetector(entries)
+			if err != nil {
+				return nil, err
+			}
+			d, hasConfig = cd, true
+		default:
+			return nil, fmt.Errorf("unknown entry mode %q", mode)
+		}
+		detectors = append(detectors, d)
+	}
+	if len(entries) > 0 && !hasConfig {
+		cd, err := newConfigDetector(entries)
+		if err != nil {
+			return nil, err
+		}
+		detectors = append(detectors, cd)
+	}
+	return detectors, nil
+}
+
+// 未指定 Detectors 时的策略：总是识别 //tracing:entry，包含测试时识别测试函数；
+// 有入口正则时只另加 config，否则项目中没有 //tracing:entry 时识别 gRPC、HTTP 与 main.main
+func (i *InsPara) entryDetectors() ([]EntryDetector, error) {
+	if i.Detectors != nil {
+		return i.Detectors, nil
+	}
+	modes := []string{EntryDirective}
+	if i.Tests {
+		modes = append(modes, EntryTest)
+	}
+	if len(i.Entries) == 0 && !i.hasEntryDirective() {
+		modes = append(modes, EntryGRPC, EntryHTTP, EntryMain)
+	}
+	return ParseEntryModes(modes, i.Entries)
+}
+
+// 项目中是否有 //tracing:entry
+func (i *InsPara) hasEntryDirective() bool {
+	for _, pkg := range i.Project.Pm {
+		for _, file := range pkg.Fm {
+			for _, fu := range file.FunMember {
+				if fu.Directives.Entry && !i.Project.Ignored(fu) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// 第一个识别出该函数的策略，不是入口时返回 nil
+func (i *InsPara) detectEntry(detectors []EntryDetector, funcMember *analysis.Member) EntryDetector {
+	for _, d := range detectors {
+		if d.Detect(i, funcMember) {
+			return d
+		}
+	}
+	return nil
+}
+
+// 按签名选择采集代码，供不关心签名的策略使用
+func (i *InsPara) captureBySignature(funcMember *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	for _, d := range []EntryDetector{httpDetector{}, grpcDetector{}} {
+		if d.Detect(i, funcMember) {
+			return d.Capture(i, funcMember, ft)
+		}
+	}
+	return nil
+}
+
+type httpDetector struct{}
+
+func (httpDetector) Name() string { return EntryHTTP }
+
+func (httpDetector) Detect(i *InsPara, m *analysis.Member) bool {
+	return isHttpHandler(m.Fun.Params)
+}
+
+func (httpDetector) Capture(i *InsPara, m *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	return i.getCopyStmt(m)
+}
+
+// 最后两个参数是否为 http.ResponseWriter 与 *http.Request
+func isHttpHandler(p []*ssa.Parameter) bool {
+	if len(p) < 2 {
+		return false
+	}
+	return types.TypeString(p[len(p)-2].Type(), nil) == "net/http.ResponseWriter" &&
+		types.TypeString(p[len(p)-1].Type(), nil) == "*net/http.Request"
+}
+
+type mainDetector struct{}
+
+func (mainDetector) Name() string { return EntryMain }
+
+func (mainDetector) Detect(i *InsPara, m *analysis.Member) bool {
+	return isMainFunc(m.Fun)
+}
+
+func (mainDetector) Capture(i *InsPara, m *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	return nil
+}
+
+func isMainFunc(fn *ssa.Function) bool {
+	return fn.Pkg != nil && fn.Pkg.Pkg.Name() == "main" && fn.Name() == "main" &&
+		fn.Parent() == nil && fn.Signature.Recv() == nil
+}
+
+type initDetector struct{}
+
+func (initDetector) Name() string { return EntryInit }
+
+func (initDetector) Detect(i *InsPara, m *analysis.Member) bool {
+	return isInitFunc(m.Fun)
+}
+
+func (initDetector) Capture(i *InsPara, m *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	return nil
+}
+
+// main 包源码中的 init 函数，ssa 中命名为 init#1 ...；其他包的 init 不是进程的入口
+func isInitFunc(fn *ssa.Function) bool {
+	return fn.Pkg != nil && fn.Pkg.Pkg.Name() == "main" && fn.Parent() == nil && fn.Signature.Recv() == nil && strings.HasPrefix(fn.Name(), "init#")
+}
+
+type grpcDetector struct{}
+
+func (grpcDetector) Name() string { return EntryGRPC }
+
+func (grpcDetector) Detect(i *InsPara, m *analysis.Member) bool {
+	_, method := grpcMethod(i.grpcServices(), m.Fun)
+	return method != nil
+}
+
+func (grpcDetector) Capture(i *InsPara, m *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	return i.getGRPCStmt(m, ft)
+}
+
+// 生成代码中的 gRPC 服务接口：名为 XxxServer 且同包中有 RegisterXxxServer，查找一次后缓存
+func (i *InsPara) grpcServices() []*types.TypeName {
+	if i.grpcServers != nil {
+		return i.grpcServers
+	}
+	i.grpcServers = []*types.TypeName{}
+	for _, pkg := range i.Project.SsaProgram.AllPackages() {
+		scope := pkg.Pkg.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || !strings.HasSuffix(name, "Server") {
+				continue
+			}
+			if _, ok := tn.Type().Underlying().(*types.Interface); !ok {
+				continue
+			}
+			if _, ok := scope.Lookup("Register" + name).(*types.Func); ok {
+				i.grpcServers = append(i.grpcServers, tn)
+			}
+		}
+	}
+	return i.grpcServers
+}
+
+// 方法实现的 gRPC 服务接口及接口方法，生成的 UnimplementedXxxServer 等类型除外
+func grpcMethod(servers []*types.TypeName, fn *ssa.Function) (*types.TypeName, *types.Func) {
+	recv := fn.Signature.Recv()
+	if recv == nil || fn.Parent() != nil {
+		return nil, nil
+	}
+	t := recv.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok || strings.HasPrefix(named.Obj().Name(), "Unimplemented") || strings.HasPrefix(named.Obj().Name(), "Unsafe") {
+		return nil, nil
+	}
+	for _, server := range servers {
+		iface := server.Type().Underlying().(*types.Interface)
+		if !types.Implements(types.NewPointer(named), iface) {
+			continue
+		}
+		for idx := 0; idx < iface.NumMethods(); idx++ {
+			if method := iface.Method(idx); method.Exported() && method.Name() == fn.Name() {
+				return server, method
+			}
+		}
+	}
+	return nil, nil
+}
+
+type testDetector struct{}
+
+func (testDetector) Name() string { return EntryTest }
+
+func (testDetector) Detect(i *InsPara, m *analysis.Member) bool {
+	return isTestFunc(m)
+}
+
+// 以测试名命名 trace
+func (testDetector) Capture(i *InsPara, m *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	params := i.nameFields(m, ft.Params, "arg")
+	if len(params) != 1 {
+		return nil
+	}
+	name := &ast.CallExpr{Fun: &ast.SelectorExpr{X: params[0], Sel: ast.NewIdent("Name")}}
+	return []ast.Stmt{&ast.ExprStmt{X: i.RuntimeCall("NameTrace", name)}}
+}
+
+// 是否为测试函数：顶层的 TestXxx(*testing.T) 或 BenchmarkXxx(*testing.B)，子测试属于所在测试的 trace
+func isTestFunc(m *analysis.Member) bool {
+	fn := m.Fun
+	if !strings.HasSuffix(m.File, "_test.go") || fn.Parent() != nil || fn.Signature.Recv() != nil ||
+		fn.Signature.Params().Len() != 1 || fn.Signature.Results().Len() != 0 {
+		return false
+	}
+	prefix := ""
+	switch types.TypeString(fn.Signature.Params().At(0).Type(), nil) {
+	case "*testing.T":
+		prefix = "Test"
+	case "*testing.B":
+		prefix = "Benchmark"
+	default:
+		return false
+	}
+	rest := strings.TrimPrefix(fn.Name(), prefix)
+	// 与 go test 相同，前缀后不能紧跟小写字母
+	return rest != fn.Name() && (rest == "" || !unicode.IsLower([]rune(rest)[0]))
+}
+
+// 函数名匹配正则
+type configDetector struct {
+	entries []*regexp.Regexp
+}
+
+func newConfigDetector(entries []string) (*configDetector, error) {
+	d := &configDetector{}
+	for _, e := range entries {
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry %q: %w", e, err)
+		}
+		d.entries = append(d.entries, re)
+	}
+	return d, nil
+}
+
+func (d *configDetector) Name() string { return EntryConfig }
+
+func (d *configDetector) Detect(i *InsPara, m *analysis.Member) bool {
+	for _, re := range d.entries {
+		if re.MatchString(m.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *configDetector) Capture(i *InsPara, m *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	return i.captureBySignature(m, ft)
+}
+
+type directiveDetector struct{}
+
+func (directiveDetector) Name() string { return EntryDirective }
+
+func (directiveDetector) Detect(i *InsPara, m *analysis.Member) bool {
+	return m.Directives.Entry
+}
+
+func (directiveDetector) Capture(i *InsPara, m *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	return i.captureBySignature(m, ft)
+}
+
+type allDetector struct{}
+
+func (allDetector) Name() string { return EntryAll }
+
+func (allDetector) Detect(i *InsPara, m *analysis.Member) bool {
+	return true
+}
+
+func (allDetector) Capture(i *InsPara, m *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	return i.captureBySignature(m, ft)
+}
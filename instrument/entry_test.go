@@ -0,0 +1,154 @@
+package instrument
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// 每种入口各有可识别与不可识别的函数
+var entryModule = map[string]string{
+	"main.go": `package main
+
+import "net/http"
+
+func init() {}
+
+func main() {}
+
+func handle(w http.ResponseWriter, r *http.Request) {}
+
+func notHandler(r *http.Request, w http.ResponseWriter) {}
+
+//tracing:entry
+func work() {}
+`,
+	"main_test.go": `package main
+
+import "testing"
+
+func TestWork(t *testing.T) {
+	t.Run("sub", func(t *testing.T) {})
+}
+
+func Test(t *testing.T) {}
+
+func Testing(t *testing.T) {}
+
+func TestMain(m *testing.M) {}
+
+func BenchmarkWork(b *testing.B) {}
+
+func check(t *testing.T) {}
+`,
+	"cmd/tool/main.go": `package main
+
+func init() {}
+
+func main() {}
+`,
+	"lib/lib.go": `package lib
+
+import "net/http"
+
+func init() {}
+
+func main() {}
+
+func Handle(w http.ResponseWriter, r *http.Request) {}
+
+func Process() {}
+
+func Processor() {}
+`,
+	"lib/lib_test.go": `package lib
+
+import "testing"
+
+func TestProcess(t *testing.T) {}
+`,
+	"pb/greeter.go": `package pb
+
+import "context"
+
+type Req struct{}
+
+type Resp struct{}
+
+type GreeterServer interface {
+	SayHello(context.Context, *Req) (*Resp, error)
+}
+
+func RegisterGreeterServer(s interface{}, srv GreeterServer) {}
+
+type UnimplementedGreeterServer struct{}
+
+func (UnimplementedGreeterServer) SayHello(context.Context, *Req) (*Resp, error) { return nil, nil }
+`,
+	"svc/server.go": `package svc
+
+import (
+	"context"
+
+	"example.com/app/pb"
+)
+
+type server struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (s *server) SayHello(ctx context.Context, req *pb.Req) (*pb.Resp, error) { return nil, nil }
+
+func (s *server) helper() {}
+
+func Register() { pb.RegisterGreeterServer(nil, &server{}) }
+`,
+}
+
+func TestDetectors(t *testing.T) {
+	config, err := newConfigDetector([]string{`lib\.Process$`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		detector EntryDetector
+		want     []string
+	}{
+		{detector: httpDetector{}, want: []string{"example.com/app.handle", "example.com/app/lib.Handle"}},
+		{detector: grpcDetector{}, want: []string{"(*example.com/app/svc.server).SayHello"}},
+		{detector: mainDetector{}, want: []string{"example.com/app.main", "example.com/app/cmd/tool.main"}},
+		{detector: initDetector{}, want: []string{"example.com/app.init#1", "example.com/app/cmd/tool.init#1"}},
+		{detector: testDetector{}, want: []string{
+			"example.com/app.BenchmarkWork", "example.com/app.Test", "example.com/app.TestWork", "example.com/app/lib.TestProcess",
+		}},
+		{detector: config, want: []string{"example.com/app/lib.Process"}},
+		{detector: directiveDetector{}, want: []string{"example.com/app.work"}},
+	}
+
+	i, err := NewInstrument(writeModule(t, entryModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Tests = true
+	if err := i.parseProject(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.detector.Name(), func(t *testing.T) {
+			got := []string{}
+			for _, pkg := range i.Project.Pm {
+				for _, file := range pkg.Fm {
+					for _, fu := range file.FunMember {
+						if tt.detector.Detect(i, fu) {
+							got = append(got, fu.Name)
+						}
+					}
+				}
+			}
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package instrument
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// 替换为运行时函数的退出调用，key 为被调函数的全名
+var exitCalls = map[string]string{
+	"os.Exit":               "Exit",
+	"log.Fatal":             "Fatal",
+	"log.Fatalf":            "Fatalf",
+	"log.Fatalln":           "Fatalln",
+	"(*log.Logger).Fatal":   "Fatal",
+	"(*log.Logger).Fatalf":  "Fatalf",
+	"(*log.Logger).Fatalln": "Fatalln",
+}
+
+// 将函数中的 os.Exit 替换为运行时的 Exit，log.Fatal(v) 与 l.Fatal(v) 替换为运行时的 Fatal(log.Output, v)
+// 与 Fatal(l.Output, v)，退出前输出进程级的 trace；调用由类型信息识别，不受导入名与同名变量的影响，
+// 按被调函数名的行列号对应到改写中的语法树
+func (i *InsPara) rewriteExit(funcMember *analysis.Member, body *ast.BlockStmt) {
+	info := i.Project.Info[funcMember.Pkg.Pkg]
+	if info == nil {
+		return
+	}
+	fset := i.Project.SsaProgram.Fset
+	type lineCol struct{ line, col int }
+	sites := make(map[lineCol]string)
+	for ident, obj := range info.Uses {
+		fn, ok := obj.(*types.Func)
+		if !ok {
+			continue
+		}
+		if wrapper, ok := exitCalls[fn.FullName()]; ok {
+			if pos := fset.Position(ident.Pos()); pos.Filename == funcMember.File {
+				sites[lineCol{pos.Line, pos.Column}] = wrapper
+			}
+		}
+	}
+	// 方法需为 l.Fatal 形式，且 l.Output 为 *log.Logger 的 Output，未被嵌入者覆盖
+	for sel, s := range info.Selections {
+		pos := fset.Position(sel.Sel.Pos())
+		if _, ok := sites[lineCol{pos.Line, pos.Column}]; ok && (s.Kind() != types.MethodVal || !loggerOutput(s.Recv())) {
+			delete(sites, lineCol{pos.Line, pos.Column})
+		}
+	}
+	if len(sites) == 0 {
+		return
+	}
+
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := astutil.Unparen(call.Fun).(*ast.SelectorExpr)
+		if !ok || !sel.Sel.Pos().IsValid() {
+			return true
+		}
+		pos := fset.Position(sel.Sel.Pos())
+		wrapper, ok := sites[lineCol{pos.Line, pos.Column}]
+		if !ok {
+			return true
+		}
+		if wrapper == "Exit" {
+			i.rewriteMap[funcMember.File].exits = true
+		} else {
+			output := &ast.SelectorExpr{X: sel.X, Sel: ast.NewIdent("Output")}
+			call.Args = append([]ast.Expr{output}, call.Args...)
+		}
+		call.Fun = &ast.SelectorExpr{X: i.runtimeIdent(), Sel: ast.NewIdent(wrapper)}
+		return true
+	})
+}
+
+// recv 的 Output 方法是否为 *log.Logger 的 Output
+func loggerOutput(recv types.Type) bool {
+	output, _, _ := types.LookupFieldOrMethod(recv, true, nil, "Output")
+	fn, ok := output.(*types.Func)
+	return ok && fn.FullName() == "(*log.Logger).Output"
+}
+
+// 删除不再使用的导入
+func removeUnusedImport(fset *token.FileSet, f *ast.File, path string) {
+	for _, spec := range f.Imports {
+		if spec.Path.Value != `"`+path+`"` {
+			continue
+		}
+		if spec.Name != nil && (spec.Name.Name == "_" || spec.Name.Name == ".") {
+			continue
+		}
+		if !astutil.UsesImport(f, path) {
+			name := ""
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			astutil.DeleteNamedImport(fset, f, name, path)
+		}
+		return
+	}
+}
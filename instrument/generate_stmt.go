@@ -4,19 +4,32 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
 
 	"github.com/Shanjm/tracing-aspect/analysis"
 )
 
-func (i *InsPara) getStartStmt() []ast.Stmt {
+// 入口处开始与结束记录并以函数名命名 trace，main.main 与 init 使用进程级的 trace，init 中只开始不结束
+func (i *InsPara) getStartStmt(funcMember *analysis.Member) []ast.Stmt {
+	start, stop := "StartMultiMode", "StopMultiMode"
+	nameStmt := &ast.ExprStmt{
+		X: i.RuntimeCall("NameTrace", &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(funcMember.Name)}),
+	}
+	switch {
+	case isInitFunc(funcMember.Fun):
+		return []ast.Stmt{&ast.ExprStmt{X: i.RuntimeCall("StartMainMode")}, nameStmt}
+	case isMainFunc(funcMember.Fun):
+		start, stop = "StartMainMode", "StopMainMode"
+	}
+
 	var callStmt *ast.ExprStmt = &ast.ExprStmt{
 		X: &ast.CallExpr{
 			Fun: &ast.SelectorExpr{
-				X: &ast.Ident{
-					Name: PackageName,
-				},
+				X: i.runtimeIdent(),
 				Sel: &ast.Ident{
-					Name: "StartMultiMode",
+					Name: start,
 				},
 			},
 		},
@@ -25,22 +38,24 @@ func (i *InsPara) getStartStmt() []ast.Stmt {
 	var deferStmt *ast.DeferStmt = &ast.DeferStmt{
 		Call: &ast.CallExpr{
 			Fun: &ast.SelectorExpr{
-				X: &ast.Ident{
-					Name: PackageName,
-				},
+				X: i.runtimeIdent(),
 				Sel: &ast.Ident{
-					Name: "StopMultiMode",
+					Name: stop,
 				},
 			},
 		},
 	}
 
-	return []ast.Stmt{deferStmt, callStmt}
+	return []ast.Stmt{deferStmt, callStmt, nameStmt}
 }
 
-// TODO
+// 复制 http 请求与响应，供 DumpOriHttp 记录
 func (i *InsPara) getCopyStmt(funcMember *analysis.Member) []ast.Stmt {
 	p := funcMember.Fun.Params
+	if !isHttpHandler(p) {
+		// 非 http 处理函数，不复制请求与响应
+		return nil
+	}
 	var reassignStmt *ast.AssignStmt = &ast.AssignStmt{
 		Tok: token.ASSIGN,
 		Lhs: []ast.Expr{
@@ -51,9 +66,7 @@ func (i *InsPara) getCopyStmt(funcMember *analysis.Member) []ast.Stmt {
 		Rhs: []ast.Expr{
 			&ast.CallExpr{
 				Fun: &ast.SelectorExpr{
-					X: &ast.Ident{
-						Name: PackageName,
-					},
+					X: i.runtimeIdent(),
 					Sel: &ast.Ident{
 						Name: "NewRecorder",
 					},
@@ -70,9 +83,7 @@ func (i *InsPara) getCopyStmt(funcMember *analysis.Member) []ast.Stmt {
 	var deferStmt *ast.DeferStmt = &ast.DeferStmt{
 		Call: &ast.CallExpr{
 			Fun: &ast.SelectorExpr{
-				X: &ast.Ident{
-					Name: PackageName,
-				},
+				X: i.runtimeIdent(),
 				Sel: &ast.Ident{
 					Name: "DumpOriHttp",
 				},
@@ -90,20 +101,19 @@ func (i *InsPara) getCopyStmt(funcMember *analysis.Member) []ast.Stmt {
 	return []ast.Stmt{reassignStmt, deferStmt}
 }
 
-func (i *InsPara) getParentIdStmt() []ast.Stmt {
+func (i *InsPara) getParentIdStmt(funcMember *analysis.Member) []ast.Stmt {
+	i.declare(funcMember, i.ParentIdName)
 	var getIdStmt *ast.AssignStmt = &ast.AssignStmt{
 		Tok: token.DEFINE,
 		Lhs: []ast.Expr{
 			&ast.Ident{
-				Name: ParentId,
+				Name: i.ParentIdName,
 			},
 		},
 		Rhs: []ast.Expr{
 			&ast.CallExpr{
 				Fun: &ast.SelectorExpr{
-					X: &ast.Ident{
-						Name: "goid",
-					},
+					X: i.pkgIdent(goidModule, "goid"),
 					Sel: &ast.Ident{
 						Name: "Get",
 					},
@@ -115,32 +125,137 @@ func (i *InsPara) getParentIdStmt() []ast.Stmt {
 	return []ast.Stmt{getIdStmt}
 }
 
-func (i *InsPara) getInputStmt(funcMember *analysis.Member) []ast.Stmt {
-	// 构造参数
+// 开始函数调用的 span，返回时结束：
+//
+//	defer goreport.ReportInput("pkg.Func", "pkg/file.go:12", recv, args...)()
+func (i *InsPara) getInputStmt(funcMember *analysis.Member, recv *ast.FieldList, ft *ast.FuncType) []ast.Stmt {
+	// 构造参数，包括接收者
 	args := []ast.Expr{
-		&ast.BasicLit{
-			Value: fmt.Sprintf("\"函数名：%s\"", funcMember.Name),
+		&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(funcMember.Name)},
+		&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(i.joinPointPos(funcMember))},
+	}
+	args = append(args, i.nameFields(funcMember, recv, "recv")...)
+	args = append(args, redactArgs(funcMember, i.nameFields(funcMember, ft.Params, "arg"))...)
+
+	var deferStmt *ast.DeferStmt = &ast.DeferStmt{
+		Call: &ast.CallExpr{
+			Fun: i.RuntimeCall("ReportInput", args...),
 		},
 	}
-	for _, para := range funcMember.Fun.Params {
-		args = append(args, &ast.Ident{
-			Name: para.Name(),
+
+	return []ast.Stmt{deferStmt}
+}
+
+// 脱敏参数记录的值
+const redacted = "[REDACTED]"
+
+// 将 //tracing:redact 标记的参数替换为占位字符串
+func redactArgs(funcMember *analysis.Member, args []ast.Expr) []ast.Expr {
+	if len(funcMember.Directives.Redact) == 0 {
+		return args
+	}
+	for idx, arg := range args {
+		if ident, ok := arg.(*ast.Ident); ok {
+			if _, ok := funcMember.Directives.Redact[ident.Name]; ok {
+				args[idx] = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(redacted)}
+			}
+		}
+	}
+	return args
+}
+
+// 生成的 gRPC 采集代码引用的包，被插桩项目使用 gRPC 时已经引入
+const (
+	grpcMetadataPkg = "google.golang.org/grpc/metadata"
+	grpcStatusPkg   = "google.golang.org/grpc/status"
+)
+
+// 记录 gRPC 请求、响应、状态码与元数据，返回值需要命名以便在 defer 中读取：
+//
+//	defer func() {
+//		_md, _ := metadata.FromIncomingContext(ctx)
+//		goreport.DumpGRPC("pb.Greeter/SayHello", _md, in, _ret_arg_0, status.Code(_ret_arg_1))
+//	}()
+//
+// 流式方法的上下文取自 stream.Context()，流本身不记录：服务端流式方法记录请求消息，
+// 客户端流式与双向流式方法的请求为 nil，响应都为 nil
+func (i *InsPara) getGRPCStmt(funcMember *analysis.Member, ft *ast.FuncType) []ast.Stmt {
+	server, method := grpcMethod(i.grpcServices(), funcMember.Fun)
+	if method == nil {
+		return nil
+	}
+	sig := funcMember.Fun.Signature
+	results := sig.Results()
+	if results.Len() == 0 || results.Len() > 2 || types.TypeString(results.At(results.Len()-1).Type(), nil) != "error" {
+		return nil
+	}
+
+	params := i.nameFields(funcMember, ft.Params, "arg")
+	var ctx, req ast.Expr
+	for idx := 0; idx < sig.Params().Len(); idx++ {
+		t := sig.Params().At(idx).Type()
+		switch {
+		case types.TypeString(t, nil) == "context.Context":
+			ctx = params[idx]
+		case hasContextMethod(t):
+			ctx = &ast.CallExpr{Fun: &ast.SelectorExpr{X: params[idx], Sel: ast.NewIdent("Context")}}
+		case req == nil:
+			req = params[idx]
+		}
+	}
+	if req == nil {
+		req = ast.NewIdent("nil")
+	}
+
+	names := i.nameFields(funcMember, ft.Results, "ret_arg")
+	var rsp ast.Expr = ast.NewIdent("nil")
+	if len(names) == 2 {
+		rsp = names[0]
+	}
+	code := &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: i.pkgIdent(grpcStatusPkg, "status"), Sel: ast.NewIdent("Code")},
+		Args: []ast.Expr{names[len(names)-1]},
+	}
+
+	body := []ast.Stmt{}
+	var md ast.Expr = ast.NewIdent("nil")
+	if ctx != nil {
+		name := i.varName(funcMember, "md")
+		md = ast.NewIdent(name)
+		body = append(body, &ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent(name), ast.NewIdent("_")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{&ast.CallExpr{
+				Fun:  &ast.SelectorExpr{X: i.pkgIdent(grpcMetadataPkg, "metadata"), Sel: ast.NewIdent("FromIncomingContext")},
+				Args: []ast.Expr{ctx},
+			}},
 		})
 	}
+	name := fmt.Sprintf("%s.%s/%s", server.Pkg().Name(), strings.TrimSuffix(server.Name(), "Server"), method.Name())
+	body = append(body, &ast.ExprStmt{
+		X: i.RuntimeCall("DumpGRPC", &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(name)}, md, req, rsp, code),
+	})
 
-	var insertStmt *ast.ExprStmt = &ast.ExprStmt{
-		X: &ast.CallExpr{
-			Fun: &ast.SelectorExpr{
-				X: &ast.Ident{
-					Name: PackageName,
-				},
-				Sel: &ast.Ident{
-					Name: "ReportInput",
+	return []ast.Stmt{
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.FuncLit{
+					Type: &ast.FuncType{Params: &ast.FieldList{}},
+					Body: &ast.BlockStmt{List: body},
 				},
 			},
-			Args: args,
 		},
 	}
+}
 
-	return []ast.Stmt{insertStmt}
+// 是否有 Context() context.Context 方法，即 gRPC 流
+func hasContextMethod(t types.Type) bool {
+	obj, _, _ := types.LookupFieldOrMethod(t, true, nil, "Context")
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return false
+	}
+	sig := fn.Type().(*types.Signature)
+	return sig.Params().Len() == 0 && sig.Results().Len() == 1 &&
+		types.TypeString(sig.Results().At(0).Type(), nil) == "context.Context"
 }
@@ -0,0 +1,118 @@
+package instrument
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// 生成代码中的 gRPC 服务接口，无需依赖 grpc
+const grpcPB = `package pb
+
+import "context"
+
+type Req struct{ Name string }
+
+type Reply struct{ Message string }
+
+type Greeter_ListServer interface {
+	Send(*Reply) error
+	Context() context.Context
+}
+
+type Greeter_ChatServer interface {
+	Send(*Reply) error
+	Recv() (*Req, error)
+	Context() context.Context
+}
+
+type GreeterServer interface {
+	SayHello(context.Context, *Req) (*Reply, error)
+	List(*Req, Greeter_ListServer) error
+	Chat(Greeter_ChatServer) error
+}
+
+func RegisterGreeterServer(s interface{}, srv GreeterServer) {}
+`
+
+const grpcServer = `package main
+
+import (
+	"context"
+
+	"example.com/app/pb"
+)
+
+type server struct{}
+
+func (s *server) SayHello(ctx context.Context, in *pb.Req) (*pb.Reply, error) {
+	return &pb.Reply{Message: in.Name}, nil
+}
+
+func (s *server) List(in *pb.Req, stream pb.Greeter_ListServer) error {
+	return stream.Send(&pb.Reply{Message: in.Name})
+}
+
+func (s *server) Chat(stream pb.Greeter_ChatServer) error {
+	_, err := stream.Recv()
+	return err
+}
+
+func main() {
+	pb.RegisterGreeterServer(nil, &server{})
+}
+`
+
+func TestGRPCStmt(t *testing.T) {
+	root := writeModule(t, map[string]string{
+		"pb/pb.go": grpcPB,
+		"main.go":  grpcServer,
+	})
+	// 生成的代码依赖 grpc，不做类型检查
+	diff, err := instrumentDiff(t, root, func(i *InsPara) {
+		i.SkipVerify = true
+		i.Detectors = []EntryDetector{grpcDetector{}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		ctx    string // 取元数据的上下文
+		dump   string // 请求、响应与状态码
+	}{
+		{method: "SayHello", ctx: "ctx", dump: "_md, in, _ret_arg_0, status.Code(_ret_arg_1)"},
+		{method: "List", ctx: "stream.Context()", dump: "_md, in, nil, status.Code(_ret_arg_0)"},
+		{method: "Chat", ctx: "stream.Context()", dump: "_md, nil, nil, status.Code(_ret_arg_0)"},
+	}
+	for _, tt := range tests {
+		body := funcDiff(diff, tt.method)
+		if body == "" {
+			t.Errorf("%s is not instrumented:\n%s", tt.method, diff)
+			continue
+		}
+		if want := "metadata.FromIncomingContext(" + tt.ctx + ")"; !strings.Contains(body, want) {
+			t.Errorf("%s: no %s in\n%s", tt.method, want, body)
+		}
+		if want := `DumpGRPC("pb.Greeter/` + tt.method + `", ` + tt.dump + ")"; !strings.Contains(body, want) {
+			t.Errorf("%s: no %s in\n%s", tt.method, want, body)
+		}
+	}
+}
+
+// diff 中顶层函数的开头
+var nextFunc = regexp.MustCompile(`\n[ +-]func `)
+
+// diff 中方法 name 改写后的函数体，到下一个函数为止
+func funcDiff(diff, name string) string {
+	loc := regexp.MustCompile(`\n[ +]func \(.*\) ` + name + `\(`).FindStringIndex(diff)
+	if loc == nil {
+		return ""
+	}
+	body := diff[loc[1]:]
+	if loc := nextFunc.FindStringIndex(body); loc != nil {
+		body = body[:loc[0]]
+	}
+	return body
+}
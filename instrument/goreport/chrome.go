@@ -0,0 +1,86 @@
+package goreport
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+)
+
+// ChromeExporter 以 Chrome Trace Event JSON 导出，可在 Perfetto 或 chrome://tracing 中打开；
+// 每个协程一条轨道，函数调用为嵌套的 X 事件。Path 为目录时每个 trace 一个文件，Endpoint 不为空时 POST
+type ChromeExporter struct {
+	Endpoint string
+	Path     string
+	Service  string // 进程名，为空时见 serviceName
+
+	out sink
+}
+
+// 见 Trace Event Format 文档
+type chromeTrace struct {
+	TraceEvents     []chromeEvent `json:"traceEvents"`
+	DisplayTimeUnit string        `json:"displayTimeUnit"`
+}
+
+type chromeEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat,omitempty"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`  // 相对最早的 trace 开始的微秒
+	Dur  float64           `json:"dur"` // 微秒，X 事件为 0 时也要有，否则视为未结束
+	Pid  int               `json:"pid"`
+	Tid  int64             `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+func (e *ChromeExporter) Export(root *Span) error {
+	return e.exportBatch([]*Span{root})
+}
+
+func (e *ChromeExporter) exportBatch(roots []*Span) error {
+	return e.out.export(e.Path, e.Endpoint, roots, e.encode)
+}
+
+// 多个 trace 在同一条时间线上，时间相对最早开始的 trace
+func (e *ChromeExporter) encode(roots []*Span) (string, []byte, error) {
+	service := e.Service
+	if service == "" {
+		service = serviceName()
+	}
+	start := roots[0].Start
+	for _, root := range roots {
+		if root.Start.Before(start) {
+			start = root.Start
+		}
+	}
+	pid := os.Getpid()
+	events := []chromeEvent{{Name: "process_name", Ph: "M", Pid: pid, Tid: roots[0].Goid, Args: map[string]string{"name": service}}}
+	tracks := map[int64]bool{}
+	for _, root := range roots {
+		for _, s := range flatten(root) {
+			if !tracks[s.Goid] {
+				tracks[s.Goid] = true
+				events = append(events, chromeEvent{
+					Name: "thread_name", Ph: "M", Pid: pid, Tid: s.Goid,
+					Args: map[string]string{"name": "goroutine " + strconv.FormatInt(s.Goid, 10)},
+				})
+			}
+			args := map[string]string{}
+			for _, kv := range spanAttrs(s) {
+				args[kv[0]] = kv[1]
+			}
+			events = append(events, chromeEvent{
+				Name: s.Name,
+				Cat:  s.Kind,
+				Ph:   "X",
+				Ts:   float64(s.Start.Sub(start).Nanoseconds()) / 1000,
+				Dur:  float64(s.End.Sub(s.Start).Nanoseconds()) / 1000,
+				Pid:  pid,
+				Tid:  s.Goid,
+				Args: args,
+			})
+		}
+	}
+	payload, err := json.Marshal(chromeTrace{TraceEvents: events, DisplayTimeUnit: "ms"})
+	return spanHexID(roots[0].ID), payload, err
+}
@@ -0,0 +1,167 @@
+package goreport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// 记录请求体与响应体的最大字节数，可由环境变量 TRACING_BODY_LIMIT 修改
+var (
+	bodyLimit     = 4096
+	bodyLimitOnce sync.Once
+)
+
+func getBodyLimit() int {
+	bodyLimitOnce.Do(func() {
+		if v, err := strconv.Atoi(os.Getenv("TRACING_BODY_LIMIT")); err == nil && v >= 0 {
+			bodyLimit = v
+		}
+	})
+	return bodyLimit
+}
+
+// 只保存前 limit 个字节，响应体在调用方读取时写入
+type limitBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitBuffer) Write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			p = p[:room]
+		}
+		b.buf.Write(p)
+	}
+}
+
+func (b *limitBuffer) String() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// 读取时记录响应体
+type bodyRecorder struct {
+	io.ReadCloser
+	buf *limitBuffer
+}
+
+func (r *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.buf.Write(p[:n])
+	return n, err
+}
+
+// 在当前协程的 tracer 下记录调用结果，req 为 nil 时取响应中的请求
+func recordClient(method, rawURL string, req *http.Request, start time.Time, rsp *http.Response, err error) {
+	trace, ok := TracerManager.Load(goid.Get())
+	if !ok {
+		return
+	}
+	t := trace.(*tracer)
+
+	attrs := map[string]string{}
+	if rsp != nil && rsp.Request != nil {
+		req = rsp.Request
+	}
+	limit := getBodyLimit()
+	if req != nil {
+		method, rawURL = req.Method, req.URL.String()
+		if len(req.Header) > 0 {
+			attrs["http.request.header"] = fmt.Sprint(req.Header)
+		}
+		if req.GetBody != nil && limit > 0 {
+			if body, err := req.GetBody(); err == nil {
+				b, _ := io.ReadAll(io.LimitReader(body, int64(limit)))
+				body.Close()
+				if len(b) > 0 {
+					attrs["http.request.body"] = string(b)
+				}
+			}
+		}
+	}
+	attrs["http.method"], attrs["http.url"] = method, rawURL
+	var rspBody *limitBuffer
+	if rsp != nil {
+		attrs["http.status_code"] = strconv.Itoa(rsp.StatusCode)
+		if len(rsp.Header) > 0 {
+			attrs["http.response.header"] = fmt.Sprint(rsp.Header)
+		}
+		// 101 的响应体可写，不能替换
+		if rsp.Body != nil && rsp.StatusCode != http.StatusSwitchingProtocols && limit > 0 {
+			rspBody = &limitBuffer{limit: limit}
+			rsp.Body = &bodyRecorder{ReadCloser: rsp.Body, buf: rspBody}
+		}
+	}
+	if err != nil {
+		attrs["error"] = err.Error()
+	}
+
+	s := t.record(method+" "+rawURL, KindHTTPClient, start, attrs)
+	if rspBody != nil {
+		// 响应体在调用方读取后才完整，输出前再记录
+		lock.Lock()
+		t.onFinish(func() {
+			if body := rspBody.String(); body != "" {
+				s.Attrs["http.response.body"] = body
+			}
+		})
+		lock.Unlock()
+	}
+}
+
+// ClientDo 替换 (*http.Client).Do
+func ClientDo(do func(*http.Request) (*http.Response, error), req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	rsp, err := do(req)
+	recordClient("", "", req, start, rsp, err)
+	return rsp, err
+}
+
+// ClientGet 替换 http.Get 与 (*http.Client).Get
+func ClientGet(get func(string) (*http.Response, error), url string) (*http.Response, error) {
+	start := time.Now()
+	rsp, err := get(url)
+	recordClient(http.MethodGet, url, nil, start, rsp, err)
+	return rsp, err
+}
+
+// ClientHead 替换 http.Head 与 (*http.Client).Head
+func ClientHead(head func(string) (*http.Response, error), url string) (*http.Response, error) {
+	start := time.Now()
+	rsp, err := head(url)
+	recordClient(http.MethodHead, url, nil, start, rsp, err)
+	return rsp, err
+}
+
+// ClientPost 替换 http.Post 与 (*http.Client).Post
+func ClientPost(post func(string, string, io.Reader) (*http.Response, error), url, contentType string, body io.Reader) (*http.Response, error) {
+	start := time.Now()
+	rsp, err := post(url, contentType, body)
+	recordClient(http.MethodPost, url, nil, start, rsp, err)
+	return rsp, err
+}
+
+// ClientPostForm 替换 http.PostForm 与 (*http.Client).PostForm
+func ClientPostForm(postForm func(string, url.Values) (*http.Response, error), rawURL string, data url.Values) (*http.Response, error) {
+	start := time.Now()
+	rsp, err := postForm(rawURL, data)
+	recordClient(http.MethodPost, rawURL, nil, start, rsp, err)
+	return rsp, err
+}
@@ -0,0 +1,9 @@
+package goreport
+
+// 插桩时按 InsPara 的配置生成，环境变量优先
+var (
+	configExporter       = "stderr" // 导出方式，见 TRACING_EXPORTER
+	configExportFile     = ""       // 导出文件，见 TRACING_EXPORT_FILE
+	configExportEndpoint = ""       // 导出的 http 地址，见 TRACING_EXPORT_ENDPOINT
+	configOwnSignals     = false    // 程序自己处理 SIGINT/SIGTERM，收到时只输出不退出
+)
@@ -0,0 +1,242 @@
+package goreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 两个 trace：http 入口返回 500，其下有函数调用与子协程中失败的 sql；gRPC 入口正常返回
+func encodeRoots() []*Span {
+	base := time.Unix(1700000000, 0)
+	at := func(s *Span, start, end int) *Span {
+		s.Start, s.End = base.Add(time.Duration(start)*time.Millisecond), base.Add(time.Duration(end)*time.Millisecond)
+		return s
+	}
+
+	web := at(newSpan("GET /users", KindEntry, 1, nil), 0, 10)
+	web.Attrs["http.status_code"] = "500"
+	fn := at(newSpan("app.load", KindFunc, 1, web), 1, 9)
+	fn.Pos, fn.Args, fn.Results = "app/load.go:12", []string{"7"}, []string{"nil"}
+	g := at(newSpan("app.load.func1", KindGoroutine, 2, fn), 2, 8)
+	q := at(newSpan("Query", KindSQL, 2, g), 3, 4)
+	q.Attrs["db.statement"] = "SELECT 1"
+	q.Attrs["error"] = "connection refused"
+
+	rpc := at(newSpan("pb.Greeter/SayHello", KindEntry, 3, nil), 5, 6)
+	rpc.Attrs["rpc.status"] = "OK"
+	return []*Span{web, rpc}
+}
+
+// 各格式中的 span 还原为同一形式比较
+type encodedSpan struct {
+	trace, id, parent, name, kind, err string
+	attrs                              map[string]string
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode encodeFunc
+		decode func(payload []byte) ([]encodedSpan, error)
+	}{
+		{name: "otlp", encode: (&OTLPExporter{Service: "svc"}).encode, decode: decodeOTLP},
+		{name: "zipkin", encode: (&ZipkinExporter{Service: "svc"}).encode, decode: decodeZipkin},
+		{name: "jaeger", encode: (&JaegerExporter{Service: "svc"}).encode, decode: decodeJaeger},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots := encodeRoots()
+			name, payload, err := tt.encode(roots)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spans, err := tt.decode(payload)
+			if err != nil {
+				t.Fatalf("decode: %v\n%s", err, payload)
+			}
+
+			want := append(flatten(roots[0]), flatten(roots[1])...)
+			if len(spans) != len(want) {
+				t.Fatalf("got %d spans, want %d", len(spans), len(want))
+			}
+			if name != spans[0].trace {
+				t.Errorf("named %s, want the first trace id %s", name, spans[0].trace)
+			}
+			for idx, s := range want {
+				got := spans[idx]
+				parent := ""
+				if s.ParentID != 0 {
+					parent = spanHexID(s.ParentID)
+				}
+				msg, _ := spanError(s)
+				if got.id != spanHexID(s.ID) || got.parent != parent || got.name != s.Name ||
+					got.kind != spanKind(s) || got.err != msg {
+					t.Errorf("span %d: got %+v, want id %s parent %q %s %s error %q",
+						idx, got, spanHexID(s.ID), parent, s.Name, spanKind(s), msg)
+				}
+				if len(got.trace) != 32 {
+					t.Errorf("span %d: trace id %q", idx, got.trace)
+				}
+				if (got.trace == spans[0].trace) != (idx < len(flatten(roots[0]))) {
+					t.Errorf("span %d is in trace %s, the first trace is %s", idx, got.trace, spans[0].trace)
+				}
+				if got.attrs["thread.id"] != fmt.Sprint(s.Goid) || got.attrs["goreport.kind"] != s.Kind {
+					t.Errorf("span %d: got attributes %v", idx, got.attrs)
+				}
+			}
+
+			for key, value := range map[string]string{
+				"code.filepath": "app/load.go",
+				"code.lineno":   "12",
+				"code.function": "app.load",
+				"args.0":        "7",
+				"results.0":     "nil",
+			} {
+				if spans[1].attrs[key] != value {
+					t.Errorf("function span: %s = %q, want %q", key, spans[1].attrs[key], value)
+				}
+			}
+			if spans[3].attrs["db.statement"] != "SELECT 1" {
+				t.Errorf("sql span: got attributes %v", spans[3].attrs)
+			}
+		})
+	}
+}
+
+func decodeOTLP(payload []byte) ([]encodedSpan, error) {
+	var traces otlpTraces
+	if err := json.Unmarshal(payload, &traces); err != nil {
+		return nil, err
+	}
+	if len(traces.ResourceSpans) != 1 || len(traces.ResourceSpans[0].ScopeSpans) != 1 {
+		return nil, fmt.Errorf("want one resource with one scope")
+	}
+	rs := traces.ResourceSpans[0]
+	if attrs := rs.Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || *attrs[0].Value.StringValue != "svc" {
+		return nil, fmt.Errorf("resource attributes %+v", attrs)
+	}
+	kinds := map[int]string{otlpKindInternal: spanInternal, otlpKindServer: spanServer, otlpKindClient: spanClient}
+	spans := []encodedSpan{}
+	for _, o := range rs.ScopeSpans[0].Spans {
+		s := encodedSpan{trace: o.TraceID, id: o.SpanID, parent: o.ParentSpanID, name: o.Name, kind: kinds[o.Kind], attrs: map[string]string{}}
+		if o.Status.Code == otlpStatusError {
+			s.err = o.Status.Message
+		}
+		for _, kv := range o.Attributes {
+			if kv.Value.StringValue != nil {
+				s.attrs[kv.Key] = *kv.Value.StringValue
+			} else {
+				s.attrs[kv.Key] = kv.Value.IntValue
+			}
+		}
+		spans = append(spans, s)
+	}
+	return spans, nil
+}
+
+func decodeZipkin(payload []byte) ([]encodedSpan, error) {
+	var zs []zipkinSpan
+	if err := json.Unmarshal(payload, &zs); err != nil {
+		return nil, err
+	}
+	spans := []encodedSpan{}
+	for _, z := range zs {
+		if z.LocalEndpoint.ServiceName != "svc" || z.Duration <= 0 {
+			return nil, fmt.Errorf("span %+v", z)
+		}
+		s := encodedSpan{trace: z.TraceID, id: z.ID, parent: z.ParentID, name: z.Name, kind: strings.ToLower(z.Kind), err: z.Tags["error"], attrs: z.Tags}
+		if s.kind == "" {
+			s.kind = spanInternal
+		}
+		spans = append(spans, s)
+	}
+	return spans, nil
+}
+
+func decodeJaeger(payload []byte) ([]encodedSpan, error) {
+	var traces jaegerTraces
+	if err := json.Unmarshal(payload, &traces); err != nil {
+		return nil, err
+	}
+	spans := []encodedSpan{}
+	for _, trace := range traces.Data {
+		if p := trace.Processes[jaegerProcessID]; p.ServiceName != "svc" {
+			return nil, fmt.Errorf("processes %+v", trace.Processes)
+		}
+		for _, j := range trace.Spans {
+			if j.TraceID != trace.TraceID || j.ProcessID != jaegerProcessID {
+				return nil, fmt.Errorf("span %+v in trace %s", j, trace.TraceID)
+			}
+			s := encodedSpan{trace: j.TraceID, id: j.SpanID, name: j.OperationName, attrs: map[string]string{}}
+			for _, ref := range j.References {
+				if ref.RefType == "CHILD_OF" && ref.TraceID == j.TraceID {
+					s.parent = ref.SpanID
+				}
+			}
+			for _, tag := range j.Tags {
+				s.attrs[tag.Key] = fmt.Sprint(tag.Value)
+			}
+			s.kind = s.attrs["span.kind"]
+			if s.attrs["error"] == "true" {
+				s.err = s.attrs["error.message"]
+			}
+			spans = append(spans, s)
+		}
+	}
+	return spans, nil
+}
+
+func TestEncodeChrome(t *testing.T) {
+	roots := encodeRoots()
+	_, payload, err := (&ChromeExporter{Service: "svc"}).encode(roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace chromeTrace
+	if err := json.Unmarshal(payload, &trace); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ph, name string
+		tid      int64
+		ts, dur  float64 // 微秒
+		args     map[string]string
+	}{
+		{ph: "M", name: "process_name", tid: 1, args: map[string]string{"name": "svc"}},
+		{ph: "M", name: "thread_name", tid: 1, args: map[string]string{"name": "goroutine 1"}},
+		{ph: "X", name: "GET /users", tid: 1, ts: 0, dur: 10000, args: map[string]string{"http.status_code": "500"}},
+		{ph: "X", name: "app.load", tid: 1, ts: 1000, dur: 8000, args: map[string]string{"args.0": "7", "code.lineno": "12"}},
+		{ph: "M", name: "thread_name", tid: 2, args: map[string]string{"name": "goroutine 2"}},
+		{ph: "X", name: "app.load.func1", tid: 2, ts: 2000, dur: 6000},
+		{ph: "X", name: "Query", tid: 2, ts: 3000, dur: 1000, args: map[string]string{"db.statement": "SELECT 1", "error": "connection refused"}},
+		{ph: "M", name: "thread_name", tid: 3, args: map[string]string{"name": "goroutine 3"}},
+		{ph: "X", name: "pb.Greeter/SayHello", tid: 3, ts: 5000, dur: 1000, args: map[string]string{"rpc.status": "OK"}},
+	}
+	if len(trace.TraceEvents) != len(tests) {
+		t.Fatalf("got %d events, want %d:\n%s", len(trace.TraceEvents), len(tests), payload)
+	}
+	for idx, tt := range tests {
+		e := trace.TraceEvents[idx]
+		if e.Ph != tt.ph || e.Name != tt.name || e.Tid != tt.tid || e.Ts != tt.ts || e.Dur != tt.dur || e.Pid != trace.TraceEvents[0].Pid {
+			t.Errorf("event %d: got %+v, want %+v", idx, e, tt)
+		}
+		for k, v := range tt.args {
+			if e.Args[k] != v {
+				t.Errorf("event %d: %s = %q, want %q", idx, k, e.Args[k], v)
+			}
+		}
+	}
+	// 耗时为 0 的调用仍有 dur
+	instant := newSpan("app.noop", KindEntry, 1, nil)
+	instant.End = instant.Start
+	if _, payload, err = (&ChromeExporter{Service: "svc"}).encode([]*Span{instant}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(payload), `"name":"app.noop","cat":"entry","ph":"X","ts":0,"dur":0,`) {
+		t.Errorf("zero-duration event lacks dur:\n%s", payload)
+	}
+}
@@ -0,0 +1,391 @@
+package goreport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Exporter 导出结束的 trace，root 为入口 span，导出后不再修改
+type Exporter interface {
+	Export(root *Span) error
+}
+
+// 内置导出方式的名字，由环境变量 TRACING_EXPORTER 或插桩时的配置选择
+const (
+	ExportStderr = "stderr" // 文本输出到标准错误，不混入程序的输出，默认
+	ExportStdout = "stdout" // 文本输出到标准输出
+	ExportJSONL  = "jsonl"  // 每个 trace 一行 JSON，写入 TRACING_EXPORT_FILE，为空则为标准输出
+	ExportMemory = "memory" // 保存在内存中，测试中由 GetExporter 取出
+	ExportOTLP   = "otlp"   // OTLP/HTTP JSON，发送到 TRACING_EXPORT_ENDPOINT，或写入 TRACING_EXPORT_FILE
+	ExportZipkin = "zipkin" // Zipkin v2 JSON，同上
+	ExportJaeger = "jaeger" // Jaeger UI 可导入的 JSON，同上
+	ExportChrome = "chrome" // Chrome Trace Event JSON，可在 Perfetto 中打开，同上
+	ExportNone   = "none"   // 不导出
+)
+
+var (
+	exporter     Exporter
+	exporterLock sync.Mutex
+)
+
+// SetExporter 替换导出方式，nil 为不导出
+func SetExporter(e Exporter) {
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	if e == nil {
+		e = noneExporter{}
+	}
+	exporter = e
+}
+
+// GetExporter 当前的导出方式，首次调用时按环境变量与插桩时的配置创建
+func GetExporter() Exporter {
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	if exporter == nil {
+		name, file, endpoint := configExporter, configExportFile, configExportEndpoint
+		if v := os.Getenv("TRACING_EXPORTER"); v != "" {
+			name = v
+		}
+		if v := os.Getenv("TRACING_EXPORT_FILE"); v != "" {
+			file = v
+		}
+		if v := os.Getenv("TRACING_EXPORT_ENDPOINT"); v != "" {
+			endpoint = v
+		}
+		e, err := newExporter(strings.TrimSpace(name), file, endpoint)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "goreport: %v，改为输出到标准错误\n", err)
+			e = &StdoutExporter{W: os.Stderr}
+		}
+		exporter = e
+	}
+	return exporter
+}
+
+func newExporter(name, file, endpoint string) (Exporter, error) {
+	switch name {
+	case ExportStderr, "":
+		return &StdoutExporter{W: os.Stderr}, nil
+	case ExportStdout:
+		return &StdoutExporter{}, nil
+	case ExportJSONL:
+		return &JSONLExporter{Path: file}, nil
+	case ExportMemory:
+		return &MemoryExporter{}, nil
+	case ExportOTLP:
+		return &OTLPExporter{Path: file, Endpoint: endpoint}, nil
+	case ExportZipkin:
+		return &ZipkinExporter{Path: file, Endpoint: endpoint}, nil
+	case ExportJaeger:
+		return &JaegerExporter{Path: file, Endpoint: endpoint}, nil
+	case ExportChrome:
+		return &ChromeExporter{Path: file, Endpoint: endpoint}, nil
+	case ExportNone:
+		return noneExporter{}, nil
+	}
+	return nil, fmt.Errorf("未知的导出方式 %q", name)
+}
+
+// 需要写文件或发送请求的导出方式，由后台协程成批导出，入口返回时不等待
+type batchExporter interface {
+	Exporter
+	exportBatch(roots []*Span) error
+}
+
+// 后台导出的队列长度与每批最多的 trace 数，队列满时丢弃新结束的 trace
+const (
+	exportQueueSize = 1024
+	exportBatchSize = 64
+	exportFlushWait = 5 * time.Second // Flush 最多等待的时间
+)
+
+var exportQueue struct {
+	start   sync.Once
+	traces  chan *Span
+	flush   chan chan struct{}
+	dropped int64 // 因队列满丢弃的 trace 数
+}
+
+// 导出 trace，失败时输出到标准错误
+func export(root *Span) {
+	e := GetExporter()
+	// go test 在所有测试结束后直接退出，不经过 Flush，测试中同步导出
+	if _, ok := e.(batchExporter); !ok || flag.Lookup("test.v") != nil {
+		if err := e.Export(root); err != nil {
+			fmt.Fprintf(os.Stderr, "goreport: 导出失败: %v\n", err)
+		}
+		return
+	}
+	startExport()
+	select {
+	case exportQueue.traces <- root:
+	default:
+		if atomic.AddInt64(&exportQueue.dropped, 1) == 1 {
+			fmt.Fprintf(os.Stderr, "goreport: 导出队列已满，丢弃 trace %s\n", root.Name)
+		}
+	}
+}
+
+func startExport() {
+	exportQueue.start.Do(func() {
+		exportQueue.traces = make(chan *Span, exportQueueSize)
+		exportQueue.flush = make(chan chan struct{})
+		go exportLoop()
+	})
+}
+
+// 后台导出队列中的 trace，每次取出已在队列中的，最多 exportBatchSize 个
+func exportLoop() {
+	for {
+		select {
+		case root := <-exportQueue.traces:
+			exportBatch(append([]*Span{root}, drainQueue(exportBatchSize-1)...))
+		case done := <-exportQueue.flush:
+			for batch := drainQueue(exportBatchSize); len(batch) > 0; batch = drainQueue(exportBatchSize) {
+				exportBatch(batch)
+			}
+			close(done)
+		}
+	}
+}
+
+// 不阻塞地取出队列中最多 n 个 trace
+func drainQueue(n int) []*Span {
+	var roots []*Span
+	for len(roots) < n {
+		select {
+		case root := <-exportQueue.traces:
+			roots = append(roots, root)
+		default:
+			return roots
+		}
+	}
+	return roots
+}
+
+func exportBatch(roots []*Span) {
+	var err error
+	if b, ok := GetExporter().(batchExporter); ok {
+		err = b.exportBatch(roots)
+	} else {
+		// 导出方式已被 SetExporter 替换
+		for _, root := range roots {
+			if e := GetExporter().Export(root); e != nil {
+				err = e
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goreport: 导出 %d 个 trace 失败: %v\n", len(roots), err)
+	}
+}
+
+// Flush 等待已结束的 trace 导出完成，最多等待 exportFlushWait，进程退出前调用；
+// 进程级的 trace 在 main 返回、Exit 与收到信号时会自动调用
+func Flush() {
+	startExport()
+	done := make(chan struct{})
+	timeout := time.After(exportFlushWait)
+	select {
+	case exportQueue.flush <- done:
+		select {
+		case <-done:
+		case <-timeout:
+			fmt.Fprintln(os.Stderr, "goreport: 等待导出超时")
+		}
+	case <-timeout:
+		fmt.Fprintln(os.Stderr, "goreport: 等待导出超时")
+	}
+	if n := atomic.LoadInt64(&exportQueue.dropped); n > 0 {
+		fmt.Fprintf(os.Stderr, "goreport: 导出队列已满，共丢弃 %d 个 trace\n", n)
+	}
+}
+
+// StdoutExporter 以缩进的文本输出 span 树
+type StdoutExporter struct {
+	W io.Writer // 为 nil 时为标准输出
+
+	mu sync.Mutex
+}
+
+func (e *StdoutExporter) Export(root *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w := e.W
+	if w == nil {
+		w = os.Stdout
+	}
+	// 整个 trace 一次写入，避免与其他输出交错
+	b := &bytes.Buffer{}
+	fmt.Fprintln(b, "-----------------START-----------------")
+	printSpan(b, root, "")
+	fmt.Fprintln(b, "------------------END------------------")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// JSONLExporter 每个 trace 写一行 JSON，Path 为空时写到标准输出
+type JSONLExporter struct {
+	Path string
+
+	out sink
+}
+
+func (e *JSONLExporter) Export(root *Span) error {
+	return e.exportBatch([]*Span{root})
+}
+
+func (e *JSONLExporter) exportBatch(roots []*Span) error {
+	return e.out.export(e.Path, "", roots, encodeJSONL)
+}
+
+// 每个 trace 一行
+func encodeJSONL(roots []*Span) (string, []byte, error) {
+	lines := [][]byte{}
+	for _, root := range roots {
+		b, err := json.Marshal(root)
+		if err != nil {
+			return "", nil, err
+		}
+		lines = append(lines, b)
+	}
+	return spanHexID(roots[0].ID), bytes.Join(lines, []byte("\n")), nil
+}
+
+// 以追加方式打开导出文件，path 为空时为标准输出
+func openExportFile(path string) (io.Writer, error) {
+	if path == "" {
+		return os.Stdout, nil
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// 导出的目标，endpoint 不为空时 POST；否则 path 为目录时写入 path/name.json，
+// 便于逐个导入 UI，其他情况每次一行追加到 path，path 也为空时为标准输出
+type sink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+var exportClient = &http.Client{Timeout: 10 * time.Second}
+
+// 按格式编码一批 trace，name 为写入目录时的文件名
+type encodeFunc func(roots []*Span) (name string, payload []byte, err error)
+
+// 导出一批 trace，发送时合并为一个请求，写入文件时每个 trace 单独编码
+func (s *sink) export(path, endpoint string, roots []*Span, encode encodeFunc) error {
+	if endpoint != "" {
+		_, payload, err := encode(roots)
+		if err != nil {
+			return err
+		}
+		return s.post(endpoint, payload)
+	}
+	for _, root := range roots {
+		name, payload, err := encode([]*Span{root})
+		if err != nil {
+			return err
+		}
+		if err := s.write(path, name, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sink) post(endpoint string, payload []byte) error {
+	rsp, err := exportClient.Post(endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, rsp.Body)
+	if rsp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s 返回 %s", endpoint, rsp.Status)
+	}
+	return nil
+}
+
+func (s *sink) write(path, name string, payload []byte) error {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return os.WriteFile(filepath.Join(path, name+".json"), payload, 0644)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		w, err := openExportFile(path)
+		if err != nil {
+			return err
+		}
+		s.w = w
+	}
+	_, err := s.w.Write(append(payload, '\n'))
+	return err
+}
+
+// 服务名，取自环境变量 OTEL_SERVICE_NAME，默认为可执行文件名
+func serviceName() string {
+	if v := os.Getenv("OTEL_SERVICE_NAME"); v != "" {
+		return v
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// 随机的 128 位 trace id，十六进制
+func newTraceID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		binary.BigEndian.PutUint64(b[8:], uint64(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b)
+}
+
+// 64 位 span id，十六进制，进程内唯一
+func spanHexID(id int64) string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
+
+// MemoryExporter 保存导出的 trace
+type MemoryExporter struct {
+	mu     sync.Mutex
+	traces []*Span
+}
+
+func (e *MemoryExporter) Export(root *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.traces = append(e.traces, root)
+	return nil
+}
+
+// Traces 已导出的 trace，按结束顺序
+func (e *MemoryExporter) Traces() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.traces...)
+}
+
+// Reset 清空已导出的 trace
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.traces = nil
+}
+
+type noneExporter struct{}
+
+func (noneExporter) Export(root *Span) error { return nil }
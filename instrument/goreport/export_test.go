@@ -0,0 +1,154 @@
+package goreport
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryExporter(t *testing.T) {
+	mem := &MemoryExporter{}
+	SetExporter(mem)
+	defer SetExporter(nil)
+
+	run := func(name string) {
+		StartMultiMode()
+		defer StopMultiMode()
+		NameTrace(name)
+		defer ReportInput("pkg.Work", "pkg/work.go:3", 1, "a")()
+		ReportOutput(true)
+	}
+	run("first")
+	run("second")
+
+	traces := mem.Traces()
+	if len(traces) != 2 {
+		t.Fatalf("got %d traces, want 2", len(traces))
+	}
+	for i, want := range []string{"first", "second"} {
+		root := traces[i]
+		if root.Name != want || root.Kind != KindEntry {
+			t.Errorf("trace %d: got %s %q, want entry %q", i, root.Kind, root.Name, want)
+		}
+		if len(root.Children) != 1 {
+			t.Fatalf("trace %d: got %d children, want 1", i, len(root.Children))
+		}
+		c := root.Children[0]
+		if c.Name != "pkg.Work" || c.ParentID != root.ID || c.Pos != "pkg/work.go:3" {
+			t.Errorf("trace %d: unexpected child %+v", i, c)
+		}
+		if strings.Join(c.Args, ",") != "1,a" || strings.Join(c.Results, ",") != "true" {
+			t.Errorf("trace %d: got args %v results %v", i, c.Args, c.Results)
+		}
+		if c.End.Before(c.Start) || root.End.Before(c.End) {
+			t.Errorf("trace %d: child ends at %v, root at %v", i, c.End, root.End)
+		}
+	}
+
+	mem.Reset()
+	if n := len(mem.Traces()); n != 0 {
+		t.Errorf("got %d traces after Reset", n)
+	}
+}
+
+func TestNewExporter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writer io.Writer // 文本导出的目标，nil 为标准输出
+		err    bool
+	}{
+		{name: "", writer: os.Stderr},
+		{name: ExportStderr, writer: os.Stderr},
+		{name: ExportStdout},
+		{name: ExportJSONL},
+		{name: ExportMemory},
+		{name: ExportNone},
+		{name: "xml", err: true},
+	}
+	for _, tt := range tests {
+		e, err := newExporter(tt.name, "", "")
+		if (err != nil) != tt.err {
+			t.Errorf("%q: got error %v", tt.name, err)
+			continue
+		}
+		if s, ok := e.(*StdoutExporter); ok && s.W != tt.writer {
+			t.Errorf("%q: writes to %v, want %v", tt.name, s.W, tt.writer)
+		}
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	root := newSpan("main.main", KindEntry, 1, nil)
+	child := newSpan("pkg.Work", KindFunc, 1, root)
+	child.Args = []string{"1"}
+	endOpen(root, root.Start)
+
+	b := &bytes.Buffer{}
+	if err := (&StdoutExporter{W: b}).Export(root); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{"START", "entry main.main", "  func pkg.Work", "参数：1", "END"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	SetExporter(&JSONLExporter{Path: path})
+	defer SetExporter(nil)
+
+	// 测试中 export 同步导出，直接放入队列
+	startExport()
+	for _, name := range []string{"a", "b", "c"} {
+		exportQueue.traces <- newSpan(name, KindEntry, 1, nil)
+	}
+	Flush()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 3 {
+		t.Errorf("got %d lines, want 3:\n%s", len(lines), b)
+	}
+}
+
+func TestExportBatchPostsOnce(t *testing.T) {
+	var requests int
+	var spans []zipkinSpan
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var batch []zipkinSpan
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		spans = append(spans, batch...)
+	}))
+	defer srv.Close()
+
+	roots := []*Span{}
+	for _, name := range []string{"a", "b", "c"} {
+		root := newSpan(name, KindEntry, 1, nil)
+		newSpan(name+".child", KindFunc, 1, root)
+		endOpen(root, root.Start)
+		roots = append(roots, root)
+	}
+	if err := (&ZipkinExporter{Endpoint: srv.URL}).exportBatch(roots); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 || len(spans) != 6 {
+		t.Fatalf("got %d requests with %d spans, want 1 with 6", requests, len(spans))
+	}
+	if spans[0].TraceID == spans[2].TraceID {
+		t.Errorf("traces share the id %s", spans[0].TraceID)
+	}
+}
@@ -0,0 +1,123 @@
+package goreport
+
+import "encoding/json"
+
+// 未配置文件与地址时发送到本机 Jaeger collector
+const defaultJaegerEndpoint = "http://localhost:14268/api/traces"
+
+// JaegerExporter 以 Jaeger 查询接口返回的 JSON 导出，即 Jaeger UI 可导入的格式；
+// Endpoint 与 Path 都为空时发送到 defaultJaegerEndpoint
+type JaegerExporter struct {
+	Endpoint string
+	Path     string // Endpoint 为空时写入的文件，为目录时每个 trace 一个文件
+	Service  string // 为空时见 serviceName
+
+	out sink
+}
+
+// 见 jaeger 的 model/json
+type jaegerTraces struct {
+	Data []jaegerTrace `json:"data"`
+}
+
+type jaegerTrace struct {
+	TraceID   string                   `json:"traceID"`
+	Spans     []jaegerSpan             `json:"spans"`
+	Processes map[string]jaegerProcess `json:"processes"`
+}
+
+type jaegerSpan struct {
+	TraceID       string            `json:"traceID"`
+	SpanID        string            `json:"spanID"`
+	OperationName string            `json:"operationName"`
+	References    []jaegerReference `json:"references"`
+	StartTime     int64             `json:"startTime"` // 微秒
+	Duration      int64             `json:"duration"`  // 微秒
+	Tags          []jaegerTag       `json:"tags"`
+	Logs          []struct{}        `json:"logs"`
+	ProcessID     string            `json:"processID"`
+}
+
+type jaegerReference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type jaegerTag struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type jaegerProcess struct {
+	ServiceName string      `json:"serviceName"`
+	Tags        []jaegerTag `json:"tags"`
+}
+
+// 一个 trace 只有一个进程
+const jaegerProcessID = "p1"
+
+func (e *JaegerExporter) Export(root *Span) error {
+	return e.exportBatch([]*Span{root})
+}
+
+func (e *JaegerExporter) exportBatch(roots []*Span) error {
+	endpoint := e.Endpoint
+	if endpoint == "" && e.Path == "" {
+		endpoint = defaultJaegerEndpoint
+	}
+	return e.out.export(e.Path, endpoint, roots, e.encode)
+}
+
+// 每个 trace 为 data 中的一项
+func (e *JaegerExporter) encode(roots []*Span) (string, []byte, error) {
+	service := e.Service
+	if service == "" {
+		service = serviceName()
+	}
+	traces := []jaegerTrace{}
+	for _, root := range roots {
+		traces = append(traces, jaegerFromSpan(newTraceID(), service, root))
+	}
+	payload, err := json.Marshal(jaegerTraces{Data: traces})
+	return traces[0].TraceID, payload, err
+}
+
+func jaegerFromSpan(traceID, service string, root *Span) jaegerTrace {
+	trace := jaegerTrace{
+		TraceID:   traceID,
+		Processes: map[string]jaegerProcess{jaegerProcessID: {ServiceName: service, Tags: []jaegerTag{}}},
+	}
+	for _, s := range flatten(root) {
+		j := jaegerSpan{
+			TraceID:       traceID,
+			SpanID:        spanHexID(s.ID),
+			OperationName: s.Name,
+			References:    []jaegerReference{},
+			StartTime:     s.Start.UnixNano() / 1000,
+			Duration:      micros(s),
+			Tags: []jaegerTag{
+				{Key: "span.kind", Type: "string", Value: spanKind(s)},
+				{Key: "thread.id", Type: "int64", Value: s.Goid},
+			},
+			Logs:      []struct{}{},
+			ProcessID: jaegerProcessID,
+		}
+		if s.ParentID != 0 {
+			j.References = append(j.References, jaegerReference{RefType: "CHILD_OF", TraceID: traceID, SpanID: spanHexID(s.ParentID)})
+		}
+		for _, kv := range spanAttrs(s) {
+			// 错误另记为 bool 标签
+			if kv[0] != "error" {
+				j.Tags = append(j.Tags, jaegerTag{Key: kv[0], Type: "string", Value: kv[1]})
+			}
+		}
+		if msg, failed := spanError(s); failed {
+			j.Tags = append(j.Tags, jaegerTag{Key: "error", Type: "bool", Value: true},
+				jaegerTag{Key: "error.message", Type: "string", Value: msg})
+		}
+		trace.Spans = append(trace.Spans, j)
+	}
+	return trace
+}
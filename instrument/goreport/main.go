@@ -0,0 +1,109 @@
+package goreport
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// 整个进程作为一个 trace，main.main 作为入口时使用
+var (
+	mainID    int64 // 进程 trace 所在协程，0 为未开启
+	mainStart sync.Once
+	mainStop  sync.Once
+)
+
+// StartMainMode 以当前协程开始进程级的 trace，可重复调用，init 与 main.main 中都可以调用
+// 正常返回、Exit、log.Fatal 以及收到 SIGINT/SIGTERM 时输出
+func StartMainMode() {
+	mainStart.Do(func() {
+		StartMultiMode()
+		atomic.StoreInt64(&mainID, goid.Get())
+
+		// 继承为忽略的信号保持忽略，如后台进程的 SIGINT
+		watched := []os.Signal{}
+		for _, sig := range []os.Signal{os.Interrupt, syscall.SIGTERM} {
+			if !signal.Ignored(sig) {
+				watched = append(watched, sig)
+			}
+		}
+		if len(watched) == 0 {
+			return
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, watched...)
+		go func() {
+			sig := <-sigs
+			// 只处理第一个信号，输出期间再次收到时按原来的方式处理
+			signal.Stop(sigs)
+			flushMain()
+			Flush()
+			if configOwnSignals {
+				// 程序自己处理信号，只输出，是否退出由程序决定
+				return
+			}
+			// 恢复默认处理并重新发送信号，保持原来的退出方式
+			signal.Reset(watched...)
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(sig)
+			}
+			// 信号没有结束进程时，按 shell 的惯例以 128+信号值退出
+			time.Sleep(time.Second)
+			code := 2
+			if signo, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(signo)
+			}
+			os.Exit(code)
+		}()
+	})
+}
+
+// StopMainMode 结束进程级的 trace，main 返回后进程即退出，等待导出完成
+func StopMainMode() {
+	mainStop.Do(func() {
+		stop(atomic.LoadInt64(&mainID), true)
+	})
+	Flush()
+}
+
+// 进程退出前输出，不等待子协程
+func flushMain() {
+	if atomic.LoadInt64(&mainID) == 0 {
+		return
+	}
+	mainStop.Do(func() {
+		stop(atomic.LoadInt64(&mainID), false)
+	})
+}
+
+// Exit 替换被插桩代码中的 os.Exit，先输出进程级的 trace 并等待导出完成
+func Exit(code int) {
+	flushMain()
+	Flush()
+	os.Exit(code)
+}
+
+// Fatal 替换被插桩代码中 log 与 *log.Logger 的 Fatal，output 为对应的 Output，
+// 日志中的位置仍为调用方，输出后同 Exit
+func Fatal(output func(calldepth int, s string) error, v ...interface{}) {
+	output(2, fmt.Sprint(v...))
+	Exit(1)
+}
+
+// Fatalf 同 Fatal，替换 Fatalf
+func Fatalf(output func(calldepth int, s string) error, format string, v ...interface{}) {
+	output(2, fmt.Sprintf(format, v...))
+	Exit(1)
+}
+
+// Fatalln 同 Fatal，替换 Fatalln
+func Fatalln(output func(calldepth int, s string) error, v ...interface{}) {
+	output(2, fmt.Sprintln(v...))
+	Exit(1)
+}
@@ -0,0 +1,145 @@
+package goreport
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// 未配置文件与地址时发送到本机 collector
+const defaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// OTLPExporter 以 OTLP/HTTP JSON 导出，一批 trace 一个请求；Endpoint 与 Path 都为空时发送到 defaultOTLPEndpoint
+type OTLPExporter struct {
+	Endpoint string
+	Path     string // Endpoint 为空时每个 trace 一行写入文件
+	Service  string // 为空时见 serviceName
+
+	out sink
+}
+
+// OTLP JSON 中用到的部分，见 opentelemetry-proto 的 trace.proto
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// int64 在 proto3 JSON 中为字符串
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    string  `json:"intValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// SpanKind 与 StatusCode 的取值
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpKindClient   = 3
+	otlpStatusError  = 2
+)
+
+func (e *OTLPExporter) Export(root *Span) error {
+	return e.exportBatch([]*Span{root})
+}
+
+func (e *OTLPExporter) exportBatch(roots []*Span) error {
+	endpoint := e.Endpoint
+	if endpoint == "" && e.Path == "" {
+		endpoint = defaultOTLPEndpoint
+	}
+	return e.out.export(e.Path, endpoint, roots, e.encode)
+}
+
+// 一个请求中包含各 trace 的所有 span，每个 trace 一个随机的 trace id
+func (e *OTLPExporter) encode(roots []*Span) (string, []byte, error) {
+	service := e.Service
+	if service == "" {
+		service = serviceName()
+	}
+	name := ""
+	spans := []otlpSpan{}
+	for _, root := range roots {
+		traceID := newTraceID()
+		if name == "" {
+			name = traceID
+		}
+		for _, s := range flatten(root) {
+			spans = append(spans, otlpFromSpan(traceID, s))
+		}
+	}
+	payload, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{otlpString("service.name", service)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "goreport"}, Spans: spans}},
+	}}})
+	return name, payload, err
+}
+
+func otlpFromSpan(traceID string, s *Span) otlpSpan {
+	o := otlpSpan{
+		TraceID:           traceID,
+		SpanID:            spanHexID(s.ID),
+		Name:              s.Name,
+		Kind:              otlpKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+	}
+	if s.ParentID != 0 {
+		o.ParentSpanID = spanHexID(s.ParentID)
+	}
+	switch spanKind(s) {
+	case spanServer:
+		o.Kind = otlpKindServer
+	case spanClient:
+		o.Kind = otlpKindClient
+	}
+	for _, kv := range spanAttrs(s) {
+		o.Attributes = append(o.Attributes, otlpString(kv[0], kv[1]))
+	}
+	o.Attributes = append(o.Attributes, otlpKeyValue{Key: "thread.id", Value: otlpAnyValue{IntValue: strconv.FormatInt(s.Goid, 10)}})
+	if msg, failed := spanError(s); failed {
+		o.Status = otlpStatus{Code: otlpStatusError, Message: msg}
+	}
+	return o
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
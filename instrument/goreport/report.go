@@ -0,0 +1,359 @@
+// Package goreport 插桩代码的运行时，插桩时会生成到被插桩项目中
+// MultiMode，支持并行模式，且尽量少的干扰源代码逻辑的方案
+package goreport
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// 包内锁
+var lock sync.Mutex
+
+// 并行方案，每个协程一个 tracer，调用记录为 span 树
+type tracer struct {
+	id       int64           // goroutine ID
+	depth    int             // 嵌套的入口数，如测试中直接调用 http 处理函数
+	span     *Span           // 本协程的根 span，入口或子协程
+	mu       sync.Mutex      // 保护 stack，子协程注册时会读取
+	stack    []*Span         // 正在执行的函数调用
+	finish   []func()        // 输出前执行，如记录调用方读完的响应体
+	children sync.Map        // 子调用
+	root     *tracer         // 指向根trace
+	wg       *sync.WaitGroup // 等待子调用结束
+}
+
+// 根 Trace
+var TracerManager sync.Map
+
+// StartMultiMode 开始并行模式，当前协程已在记录时增加嵌套层数，嵌套的入口记为子 span
+func StartMultiMode() {
+	id := goid.Get()
+	if trace, ok := TracerManager.Load(id); ok {
+		t := trace.(*tracer)
+		t.depth++
+		t.push("", KindEntry, "", nil)
+		return
+	}
+	t := &tracer{
+		id:       id,
+		span:     newSpan("", KindEntry, id, nil),
+		children: sync.Map{},
+		wg:       &sync.WaitGroup{},
+		root:     nil,
+	}
+	t.root = t
+	TracerManager.Store(id, t)
+}
+
+// 关闭子协程
+func CloseGoRoutine() {
+	id := goid.Get()
+	t, ok := TracerManager.Load(id)
+	if !ok {
+		// go 语句执行时不在 trace 中，子协程没有注册
+		return
+	}
+
+	tr := t.(*tracer)
+	tr.span.End = time.Now()
+	tr.wg.Wait() // 至少等待子协程注册完成
+	TracerManager.Delete(id)
+	tr.root.wg.Done() // 让根 tracer 减1
+}
+
+// 注册子协程，子协程的 span 挂在 parent 下，即父协程执行 go 语句时的 span，为 nil 时取父协程当前的 span
+func RegisterChildrenId(pid int64, parent *Span) {
+	cid := goid.Get()
+	ct := &tracer{
+		id:       cid,
+		children: sync.Map{},
+		wg:       &sync.WaitGroup{},
+		root:     nil,
+	}
+
+	if trace, ok := TracerManager.Load(pid); ok {
+		if t, ok := trace.(*tracer); ok {
+			ct.root = t.root
+			if parent == nil {
+				parent = t.top()
+			}
+			ct.span = newSpan("go "+parent.Name, KindGoroutine, cid, parent)
+			t.children.Store(cid, ct)
+			// 注册完成后，让父 tracer 减1
+			t.wg.Done()
+			// 只有找到父 tarcer 才能存入 TM
+			TracerManager.Store(cid, ct)
+		}
+	}
+}
+
+// IncreaseWG waitgroup 自增，在 go 语句前调用，返回当前正在执行的 span 作为子协程的父 span
+func IncreaseWG() *Span {
+	id := goid.Get()
+	if trace, ok := TracerManager.Load(id); ok {
+		if t, ok := trace.(*tracer); ok {
+			t.root.wg.Add(1) // 让根 tracer 加1
+			t.wg.Add(1)      // 本身也加1
+			return t.top()
+		}
+	}
+	return nil
+}
+
+// StopMultiMode 结束记录，嵌套的入口返回时只减少层数
+func StopMultiMode() {
+	id := goid.Get()
+	if trace, ok := TracerManager.Load(id); ok {
+		if t := trace.(*tracer); t.depth > 0 {
+			t.depth--
+			t.pop(t.entry())
+			return
+		}
+	}
+	stop(id, true)
+}
+
+// NameTrace 命名当前的入口 span，后调用的覆盖，如以测试名代替函数名
+func NameTrace(name string) {
+	if trace, ok := TracerManager.Load(goid.Get()); ok {
+		trace.(*tracer).entry().Name = name
+	}
+}
+
+// 结束 id 对应的根 tracer 并导出，wait 为是否等待子协程结束
+func stop(id int64, wait bool) {
+	t, ok := TracerManager.Load(id)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "id: %d 不存在map中\n", id)
+		return
+	}
+	rootTracer, ok := t.(*tracer)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "id: %d 不能转换为*tracer\n", id)
+	}
+
+	if wait {
+		isDone := make(chan struct{})
+		go func() {
+			rootTracer.wg.Wait()
+			close(isDone)
+		}()
+
+		select {
+		case <-time.After(30 * time.Second): // 30s 超时防止子协程卡住
+			fmt.Fprintln(os.Stderr, "超时退出root tracer")
+		case <-isDone:
+		}
+	}
+
+	TracerManager.Delete(id)
+
+	lock.Lock()
+	for _, f := range rootTracer.finish {
+		f()
+	}
+	// 根 span 在子协程结束后结束
+	endOpen(rootTracer.span, time.Now())
+	lock.Unlock()
+	export(rootTracer.span)
+}
+
+// 输出前执行 f，调用方需持有 lock
+func (t *tracer) onFinish(f func()) {
+	t.root.finish = append(t.root.finish, f)
+}
+
+// ReportInput 开始一次函数调用的 span，返回的函数结束该 span，生成代码中以 defer 调用：
+//
+//	defer goreport.ReportInput("pkg.Func", "pkg/file.go:12", args...)()
+func ReportInput(name, pos string, args ...interface{}) func() {
+	if trace, ok := TracerManager.Load(goid.Get()); ok {
+		if t, ok := trace.(*tracer); ok {
+			s := t.push(name, KindFunc, pos, args)
+			return func() { t.pop(s) }
+		}
+	}
+	return func() {}
+}
+
+// ReportOutput 记录当前函数调用的返回值
+func ReportOutput(args ...interface{}) {
+	if trace, ok := TracerManager.Load(goid.Get()); ok {
+		if t, ok := trace.(*tracer); ok {
+			s := t.top()
+			if s.Kind != KindFunc {
+				return
+			}
+			s.Results = s.Results[:0]
+			for _, arg := range args {
+				s.Results = append(s.Results, convert(arg))
+			}
+		}
+	}
+}
+
+// 转换成 string 类型的数据
+func convert(value interface{}) string {
+	if value == nil {
+		return "nil"
+	}
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.Invalid:
+		return "该参数类型为Invalid"
+	case reflect.Func:
+		return "该参数类型为Func"
+	case reflect.Chan:
+		return "该参数类型为Channel"
+	case reflect.UnsafePointer:
+		return "该参数类型为UnsafePointer"
+	case reflect.Ptr:
+		rv := reflect.ValueOf(value).Elem()
+		if rv.Kind() == reflect.Invalid {
+			return "nil"
+		}
+		if rv.CanInterface() {
+			return convert(rv.Interface())
+		}
+		return fmt.Sprint(rv)
+	case reflect.Array, reflect.Slice:
+		retV := []interface{}{}
+		isByte := true
+		arrayValue := reflect.ValueOf(value)
+		for i := 0; i < arrayValue.Len(); i++ {
+			va := arrayValue.Index(i)
+			if va.Kind() != reflect.Uint8 {
+				isByte = false
+			}
+			if va.CanInterface() {
+				retV = append(retV, va.Interface())
+			} else {
+				retV = append(retV, fmt.Sprint(va))
+			}
+		}
+		if isByte {
+			b := []byte{}
+			for _, bv := range retV {
+				b = append(b, bv.(byte))
+			}
+			return string(b)
+		}
+		return fmt.Sprint(retV)
+	case reflect.Map:
+		mv := "map["
+		it := reflect.ValueOf(value).MapRange()
+		for it.Next() {
+			k, v := it.Key(), it.Value()
+			key, vv := fmt.Sprint(k), fmt.Sprint(v)
+			if k.CanInterface() {
+				key = convert(k.Interface())
+			}
+			if v.CanInterface() {
+				vv = convert(v.Interface())
+			}
+			mv += fmt.Sprintf("%v=%v,", key, vv)
+		}
+		return mv + "]"
+	case reflect.Struct:
+		sv := reflect.ValueOf(value)
+		svString := "{"
+		for i := 0; i < sv.NumField(); i++ {
+			member, actualValue := sv.Field(i), ""
+			if member.CanInterface() {
+				actualValue = convert(member.Interface())
+			} else {
+				actualValue = fmt.Sprint(actualValue)
+			}
+			svString += actualValue + " "
+		}
+		return svString + "}"
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// Origin Http
+type HttpRecorder struct {
+	W          http.ResponseWriter
+	StatusCode int
+	Body       *bytes.Buffer
+}
+
+func NewRecorder(w http.ResponseWriter) *HttpRecorder {
+	return &HttpRecorder{
+		W:          w,
+		StatusCode: http.StatusOK,
+		Body:       new(bytes.Buffer),
+	}
+}
+
+func (rw *HttpRecorder) WriteHeader(statusCode int) {
+	rw.StatusCode = statusCode
+	rw.W.WriteHeader(statusCode)
+}
+
+func (rw *HttpRecorder) Write(buf []byte) (int, error) {
+	_, _ = rw.Body.Write(buf)
+	return rw.W.Write(buf)
+}
+
+func (rw *HttpRecorder) Header() http.Header {
+	return rw.W.Header()
+}
+
+// DumpOriHttp 在当前的入口 span 上记录 http 请求与响应
+func DumpOriHttp(req *http.Request, rw http.ResponseWriter) {
+	id := goid.Get()
+	t, ok := TracerManager.Load(id)
+	if !ok {
+		return
+	}
+
+	span := t.(*tracer).entry()
+	reqB, _ := httputil.DumpRequest(req, true)
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.url", req.URL.String())
+	span.SetAttr("http.request", string(reqB))
+	if recorder, ok := rw.(*HttpRecorder); ok {
+		span.SetAttr("http.status_code", strconv.Itoa(recorder.StatusCode))
+		span.SetAttr("http.response", recorder.Body.String())
+	}
+}
+
+// DumpGRPC 在当前的入口 span 上记录 gRPC 请求与响应，md 为 metadata.MD，code 为 codes.Code
+func DumpGRPC(method string, md map[string][]string, req, rsp interface{}, code interface{}) {
+	id := goid.Get()
+	t, ok := TracerManager.Load(id)
+	if !ok {
+		return
+	}
+
+	span := t.(*tracer).entry()
+	span.SetAttr("rpc.method", method)
+	span.SetAttr("rpc.request", message(req))
+	span.SetAttr("rpc.response", message(rsp))
+	span.SetAttr("rpc.status", fmt.Sprint(code))
+	if len(md) > 0 {
+		span.SetAttr("rpc.metadata", convert(md))
+	}
+}
+
+// proto 消息实现了 String，优先使用
+func message(v interface{}) string {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return "nil"
+	}
+	if s, ok := v.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return convert(v)
+}
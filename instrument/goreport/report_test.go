@@ -0,0 +1,50 @@
+package goreport
+
+import (
+	"testing"
+
+	"github.com/petermattis/goid"
+)
+
+// 插桩后的 go 语句在 trace 之外执行，子协程不注册也不影响之后的 trace
+func TestGoroutineOutsideTrace(t *testing.T) {
+	mem := &MemoryExporter{}
+	SetExporter(mem)
+	defer SetExporter(nil)
+
+	// 与插桩生成的代码相同
+	spawn := func(work func()) {
+		_parentId := goid.Get()
+		_span := IncreaseWG()
+		done := make(chan int64)
+		go func() {
+			// 在 CloseGoRoutine 之后通知
+			defer func() { done <- goid.Get() }()
+			defer CloseGoRoutine()
+			RegisterChildrenId(_parentId, _span)
+			work()
+		}()
+		id := <-done
+		if _, ok := TracerManager.Load(id); ok {
+			t.Errorf("goroutine %d is left registered", id)
+		}
+	}
+
+	spawn(func() {
+		defer ReportInput("pkg.Work", "pkg/work.go:3")()
+	})
+	if traces := mem.Traces(); len(traces) != 0 {
+		t.Fatalf("got %d traces outside a trace, want 0", len(traces))
+	}
+
+	func() {
+		StartMultiMode()
+		defer StopMultiMode()
+		NameTrace("entry")
+		spawn(func() {})
+	}()
+	traces := mem.Traces()
+	if len(traces) != 1 || len(traces[0].Children) != 1 || traces[0].Children[0].Kind != KindGoroutine {
+		t.Fatalf("got traces %+v, want one trace with a goroutine", traces)
+	}
+}
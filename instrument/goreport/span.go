@@ -0,0 +1,233 @@
+package goreport
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// span 的类型
+const (
+	KindEntry      = "entry"       // 入口，一个 trace 的根
+	KindGoroutine  = "goroutine"   // 插桩代码开启的子协程
+	KindFunc       = "func"        // 被追踪的函数调用
+	KindHTTPClient = "http.client" // 出站 http 调用
+	KindSQL        = "sql"         // sql 调用
+)
+
+// Span 一次调用，子协程的 span 挂在开启它时父协程正在执行的 span 下
+type Span struct {
+	ID       int64             `json:"id"`
+	Name     string            `json:"name"`
+	Goid     int64             `json:"goid"`
+	ParentID int64             `json:"parentId,omitempty"` // 根 span 为 0
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Args     []string          `json:"args,omitempty"`
+	Results  []string          `json:"results,omitempty"`
+	Pos      string            `json:"pos,omitempty"` // 函数定义位置，文件:行号
+	Kind     string            `json:"kind"`
+	Attrs    map[string]string `json:"attrs,omitempty"`
+	Children []*Span           `json:"children,omitempty"`
+}
+
+var spanID int64
+
+func newSpan(name, kind string, goid int64, parent *Span) *Span {
+	s := &Span{
+		ID:    atomic.AddInt64(&spanID, 1),
+		Name:  name,
+		Goid:  goid,
+		Start: time.Now(),
+		Kind:  kind,
+		Attrs: map[string]string{},
+	}
+	if parent != nil {
+		s.ParentID = parent.ID
+		lock.Lock()
+		parent.Children = append(parent.Children, s)
+		lock.Unlock()
+	}
+	return s
+}
+
+// SetAttr 设置属性，可在 span 结束后由其他协程调用
+func (s *Span) SetAttr(key, value string) {
+	lock.Lock()
+	defer lock.Unlock()
+	s.Attrs[key] = value
+}
+
+// 当前协程正在执行的 span
+func (t *tracer) top() *Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.stack) > 0 {
+		return t.stack[len(t.stack)-1]
+	}
+	return t.span
+}
+
+// 当前的入口 span，嵌套的入口在 stack 中
+func (t *tracer) entry() *Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for idx := len(t.stack) - 1; idx >= 0; idx-- {
+		if t.stack[idx].Kind == KindEntry {
+			return t.stack[idx]
+		}
+	}
+	return t.span
+}
+
+// 开始函数调用或嵌套入口的 span
+func (t *tracer) push(name, kind, pos string, args []interface{}) *Span {
+	s := newSpan(name, kind, t.id, t.top())
+	s.Pos = pos
+	for _, arg := range args {
+		s.Args = append(s.Args, convert(arg))
+	}
+	t.mu.Lock()
+	t.stack = append(t.stack, s)
+	t.mu.Unlock()
+	return s
+}
+
+// 结束 s 及其上尚未结束的 span，如发生 panic 时
+func (t *tracer) pop(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for idx := len(t.stack) - 1; idx >= 0; idx-- {
+		if t.stack[idx] != s {
+			continue
+		}
+		for _, open := range t.stack[idx:] {
+			open.End = now
+		}
+		t.stack = t.stack[:idx]
+		return
+	}
+}
+
+// 记录一次已结束的调用，如出站 http 与 sql
+func (t *tracer) record(name, kind string, start time.Time, attrs map[string]string) *Span {
+	s := newSpan(name, kind, t.id, t.top())
+	s.Start, s.End = start, time.Now()
+	for k, v := range attrs {
+		s.Attrs[k] = v
+	}
+	return s
+}
+
+// 按先序展开 span 树，供逐个导出 span 的格式使用
+func flatten(root *Span) []*Span {
+	spans := []*Span{root}
+	for _, c := range root.Children {
+		spans = append(spans, flatten(c)...)
+	}
+	return spans
+}
+
+// 导出为键值的 span 信息：类型、源码位置、参数、返回值与 Attrs，按键排序
+func spanAttrs(s *Span) [][2]string {
+	kvs := [][2]string{{"goreport.kind", s.Kind}}
+	if s.Pos != "" {
+		if idx := strings.LastIndex(s.Pos, ":"); idx > 0 {
+			kvs = append(kvs, [2]string{"code.filepath", s.Pos[:idx]}, [2]string{"code.lineno", s.Pos[idx+1:]})
+		}
+	}
+	if s.Kind == KindFunc {
+		kvs = append(kvs, [2]string{"code.function", s.Name})
+	}
+	for idx, arg := range s.Args {
+		kvs = append(kvs, [2]string{"args." + strconv.Itoa(idx), arg})
+	}
+	for idx, res := range s.Results {
+		kvs = append(kvs, [2]string{"results." + strconv.Itoa(idx), res})
+	}
+	for _, k := range sortedKeys(s.Attrs) {
+		kvs = append(kvs, [2]string{k, s.Attrs[k]})
+	}
+	return kvs
+}
+
+// 导出格式中 span 的类型
+const (
+	spanServer   = "server"
+	spanClient   = "client"
+	spanInternal = "internal"
+)
+
+// 入口为 server，出站 http 与 sql 为 client，其余为 internal
+func spanKind(s *Span) string {
+	switch s.Kind {
+	case KindEntry:
+		return spanServer
+	case KindHTTPClient, KindSQL:
+		return spanClient
+	}
+	return spanInternal
+}
+
+// span 是否失败及原因：记录了错误、gRPC 状态码不为 OK 或 http 入口返回 5xx
+func spanError(s *Span) (string, bool) {
+	if err, ok := s.Attrs["error"]; ok {
+		return err, true
+	}
+	if code, ok := s.Attrs["rpc.status"]; ok && code != "OK" {
+		return code, true
+	}
+	if s.Kind == KindEntry {
+		if code, err := strconv.Atoi(s.Attrs["http.status_code"]); err == nil && code >= 500 {
+			return "http " + s.Attrs["http.status_code"], true
+		}
+	}
+	return "", false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 以 end 结束尚未结束的 span，如 Exit 或收到信号时正在执行的函数
+func endOpen(s *Span, end time.Time) {
+	if s.End.IsZero() {
+		s.End = end
+	}
+	for _, c := range s.Children {
+		endOpen(c, end)
+	}
+}
+
+// 打印 span 树
+func printSpan(w io.Writer, s *Span, indent string) {
+	fmt.Fprintf(w, "%s%s %s", indent, s.Kind, s.Name)
+	if s.Pos != "" {
+		fmt.Fprintf(w, " (%s)", s.Pos)
+	}
+	fmt.Fprintf(w, " goid: %d %v\n", s.Goid, s.End.Sub(s.Start))
+	if len(s.Args) > 0 {
+		fmt.Fprintf(w, "%s  参数：%s\n", indent, strings.Join(s.Args, ", "))
+	}
+	if len(s.Results) > 0 {
+		fmt.Fprintf(w, "%s  返回：%s\n", indent, strings.Join(s.Results, ", "))
+	}
+	for _, k := range sortedKeys(s.Attrs) {
+		// 多行的值如 http 请求同样缩进
+		v := strings.ReplaceAll(strings.TrimRight(s.Attrs[k], "\n"), "\n", "\n"+indent+"    ")
+		fmt.Fprintf(w, "%s  %s: %s\n", indent, k, v)
+	}
+	for _, c := range s.Children {
+		printSpan(w, c, indent+"  ")
+	}
+}
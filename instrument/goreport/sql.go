@@ -0,0 +1,180 @@
+package goreport
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+// 设置环境变量 TRACING_SQL_REDACT 为 1 或 true 时不记录绑定参数的值
+var (
+	sqlRedact     bool
+	sqlRedactOnce sync.Once
+)
+
+func redactSQL() bool {
+	sqlRedactOnce.Do(func() {
+		v := strings.ToLower(os.Getenv("TRACING_SQL_REDACT"))
+		sqlRedact = v == "1" || v == "true"
+	})
+	return sqlRedact
+}
+
+// 在当前协程的 tracer 下记录，rows 为影响的行数，-1 为未知
+func recordSQL(op, query string, args []interface{}, start time.Time, rows int64, err error) {
+	trace, ok := TracerManager.Load(goid.Get())
+	if !ok {
+		return
+	}
+	t := trace.(*tracer)
+
+	attrs := map[string]string{"db.statement": query}
+	values := []string{}
+	for _, arg := range args {
+		if redactSQL() {
+			values = append(values, "[REDACTED]")
+			continue
+		}
+		if named, ok := arg.(sql.NamedArg); ok {
+			values = append(values, named.Name+"="+convert(named.Value))
+			continue
+		}
+		values = append(values, convert(arg))
+	}
+	if len(values) > 0 {
+		attrs["db.args"] = strings.Join(values, ", ")
+	}
+	if rows >= 0 {
+		attrs["db.rows_affected"] = strconv.FormatInt(rows, 10)
+	}
+	if err != nil {
+		attrs["error"] = err.Error()
+	}
+	t.record(op, KindSQL, start, attrs)
+}
+
+// 执行结果影响的行数
+func rowsAffected(res sql.Result, err error) int64 {
+	if err != nil || res == nil {
+		return -1
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// 预编译语句的 sql，sql.Stmt 中未导出，由反射读取，不另外保存，
+// 事务中的语句及未经插桩代码预编译的语句同样可以取到
+func stmtQuery(stmt *sql.Stmt) string {
+	if stmt != nil {
+		if q := reflect.ValueOf(stmt).Elem().FieldByName("query"); q.Kind() == reflect.String {
+			return q.String()
+		}
+	}
+	return "<unknown statement>"
+}
+
+// SQLQuery 替换 DB、Tx 的 Query
+func SQLQuery(fn func(string, ...interface{}) (*sql.Rows, error), query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := fn(query, args...)
+	recordSQL("Query", query, args, start, -1, err)
+	return rows, err
+}
+
+// SQLQueryContext 替换 DB、Tx、Conn 的 QueryContext
+func SQLQueryContext(fn func(context.Context, string, ...interface{}) (*sql.Rows, error), ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := fn(ctx, query, args...)
+	recordSQL("QueryContext", query, args, start, -1, err)
+	return rows, err
+}
+
+// SQLExec 替换 DB、Tx 的 Exec
+func SQLExec(fn func(string, ...interface{}) (sql.Result, error), query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := fn(query, args...)
+	recordSQL("Exec", query, args, start, rowsAffected(res, err), err)
+	return res, err
+}
+
+// SQLExecContext 替换 DB、Tx、Conn 的 ExecContext
+func SQLExecContext(fn func(context.Context, string, ...interface{}) (sql.Result, error), ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := fn(ctx, query, args...)
+	recordSQL("ExecContext", query, args, start, rowsAffected(res, err), err)
+	return res, err
+}
+
+// SQLQueryRow 替换 DB、Tx 的 QueryRow，错误取自 Row.Err
+func SQLQueryRow(fn func(string, ...interface{}) *sql.Row, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := fn(query, args...)
+	recordSQL("QueryRow", query, args, start, -1, row.Err())
+	return row
+}
+
+// SQLQueryRowContext 替换 DB、Tx、Conn 的 QueryRowContext
+func SQLQueryRowContext(fn func(context.Context, string, ...interface{}) *sql.Row, ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := fn(ctx, query, args...)
+	recordSQL("QueryRowContext", query, args, start, -1, row.Err())
+	return row
+}
+
+// StmtQuery 替换 Stmt.Query
+func StmtQuery(stmt *sql.Stmt, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := stmt.Query(args...)
+	recordSQL("Stmt.Query", stmtQuery(stmt), args, start, -1, err)
+	return rows, err
+}
+
+// StmtQueryContext 替换 Stmt.QueryContext
+func StmtQueryContext(stmt *sql.Stmt, ctx context.Context, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := stmt.QueryContext(ctx, args...)
+	recordSQL("Stmt.QueryContext", stmtQuery(stmt), args, start, -1, err)
+	return rows, err
+}
+
+// StmtExec 替换 Stmt.Exec
+func StmtExec(stmt *sql.Stmt, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := stmt.Exec(args...)
+	recordSQL("Stmt.Exec", stmtQuery(stmt), args, start, rowsAffected(res, err), err)
+	return res, err
+}
+
+// StmtExecContext 替换 Stmt.ExecContext
+func StmtExecContext(stmt *sql.Stmt, ctx context.Context, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := stmt.ExecContext(ctx, args...)
+	recordSQL("Stmt.ExecContext", stmtQuery(stmt), args, start, rowsAffected(res, err), err)
+	return res, err
+}
+
+// StmtQueryRow 替换 Stmt.QueryRow
+func StmtQueryRow(stmt *sql.Stmt, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := stmt.QueryRow(args...)
+	recordSQL("Stmt.QueryRow", stmtQuery(stmt), args, start, -1, row.Err())
+	return row
+}
+
+// StmtQueryRowContext 替换 Stmt.QueryRowContext
+func StmtQueryRowContext(stmt *sql.Stmt, ctx context.Context, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := stmt.QueryRowContext(ctx, args...)
+	recordSQL("Stmt.QueryRowContext", stmtQuery(stmt), args, start, -1, row.Err())
+	return row
+}
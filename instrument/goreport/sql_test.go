@@ -0,0 +1,158 @@
+package goreport
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// 桩驱动：Exec 影响的行数为参数个数，Query 返回一行，以 bad 开头的 sql 预编译失败
+type stubDriver struct{}
+
+func (stubDriver) Open(string) (driver.Conn, error) { return stubConn{}, nil }
+
+type stubConn struct{}
+
+func (stubConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.HasPrefix(query, "bad") {
+		return nil, errors.New("syntax error")
+	}
+	return stubStmt{}, nil
+}
+func (stubConn) Close() error              { return nil }
+func (stubConn) Begin() (driver.Tx, error) { return stubTx{}, nil }
+
+type stubTx struct{}
+
+func (stubTx) Commit() error   { return nil }
+func (stubTx) Rollback() error { return nil }
+
+type stubStmt struct{}
+
+func (stubStmt) Close() error  { return nil }
+func (stubStmt) NumInput() int { return -1 }
+func (stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(len(args)), nil
+}
+func (stubStmt) Query(args []driver.Value) (driver.Rows, error) { return &stubRows{}, nil }
+
+// 实现 Context 形式才能接受 sql.Named
+func (stubStmt) ExecContext(_ context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(len(args)), nil
+}
+func (stubStmt) QueryContext(context.Context, []driver.NamedValue) (driver.Rows, error) {
+	return &stubRows{}, nil
+}
+
+type stubRows struct{ done bool }
+
+func (*stubRows) Columns() []string { return []string{"n"} }
+func (*stubRows) Close() error      { return nil }
+func (r *stubRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done, dest[0] = true, int64(1)
+	return nil
+}
+
+func init() {
+	sql.Register("goreport-stub", stubDriver{})
+}
+
+func TestSQL(t *testing.T) {
+	db, err := sql.Open("goreport-stub", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		run   func(t *testing.T)
+		spans []string // 记录的 span：名字|sql|参数|影响的行数|错误
+	}{
+		{
+			name: "exec",
+			run: func(t *testing.T) {
+				SQLExec(db.Exec, "update t set a = ? where b = ?", 1, "x")
+			},
+			spans: []string{"Exec|update t set a = ? where b = ?|1, x|2|"},
+		},
+		{
+			name: "query row",
+			run: func(t *testing.T) {
+				var n int
+				SQLQueryRow(db.QueryRow, "select n from t", sql.Named("id", 3)).Scan(&n)
+			},
+			spans: []string{"QueryRow|select n from t|id=3||"},
+		},
+		{
+			name: "prepare fails",
+			run: func(t *testing.T) {
+				SQLExec(db.Exec, "bad sql")
+			},
+			spans: []string{"Exec|bad sql|||syntax error"},
+		},
+		{
+			name: "prepared statement",
+			run: func(t *testing.T) {
+				stmt, err := db.Prepare("insert into t values (?)")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer stmt.Close()
+				StmtExec(stmt, 5)
+			},
+			spans: []string{"Stmt.Exec|insert into t values (?)|5|1|"},
+		},
+		{
+			name: "transaction statement",
+			run: func(t *testing.T) {
+				stmt, err := db.Prepare("delete from t")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer stmt.Close()
+				tx, err := db.Begin()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer tx.Rollback()
+				StmtQueryRow(tx.Stmt(stmt))
+			},
+			spans: []string{"Stmt.QueryRow|delete from t|||"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := &MemoryExporter{}
+			SetExporter(mem)
+			defer SetExporter(nil)
+
+			StartMultiMode()
+			tt.run(t)
+			StopMultiMode()
+
+			traces := mem.Traces()
+			if len(traces) != 1 {
+				t.Fatalf("got %d traces", len(traces))
+			}
+			got := []string{}
+			for _, s := range traces[0].Children {
+				if s.Kind != KindSQL {
+					t.Errorf("span %s has kind %s", s.Name, s.Kind)
+				}
+				got = append(got, strings.Join([]string{s.Name, s.Attrs["db.statement"], s.Attrs["db.args"],
+					s.Attrs["db.rows_affected"], s.Attrs["error"]}, "|"))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.spans, "\n") {
+				t.Errorf("got spans\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.spans, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package goreport
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// 未配置文件与地址时发送到本机 Zipkin
+const defaultZipkinEndpoint = "http://localhost:9411/api/v2/spans"
+
+// ZipkinExporter 以 Zipkin v2 JSON 导出，每个 trace 一个 span 数组；Endpoint 与 Path 都为空时发送到 defaultZipkinEndpoint
+type ZipkinExporter struct {
+	Endpoint string
+	Path     string // Endpoint 为空时写入的文件，为目录时每个 trace 一个文件，可在 Zipkin UI 中上传
+	Service  string // 为空时见 serviceName
+
+	out sink
+}
+
+// 见 zipkin-api 的 zipkin2-api.yaml
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind,omitempty"`
+	Timestamp     int64             `json:"timestamp"` // 微秒
+	Duration      int64             `json:"duration"`  // 微秒
+	LocalEndpoint zipkinEndpoint    `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+func (e *ZipkinExporter) Export(root *Span) error {
+	return e.exportBatch([]*Span{root})
+}
+
+func (e *ZipkinExporter) exportBatch(roots []*Span) error {
+	endpoint := e.Endpoint
+	if endpoint == "" && e.Path == "" {
+		endpoint = defaultZipkinEndpoint
+	}
+	return e.out.export(e.Path, endpoint, roots, e.encode)
+}
+
+// 各 trace 的 span 放在同一个数组中
+func (e *ZipkinExporter) encode(roots []*Span) (string, []byte, error) {
+	service := e.Service
+	if service == "" {
+		service = serviceName()
+	}
+	name := ""
+	spans := []zipkinSpan{}
+	for _, root := range roots {
+		traceID := newTraceID()
+		if name == "" {
+			name = traceID
+		}
+		for _, s := range flatten(root) {
+			z := zipkinSpan{
+				TraceID:       traceID,
+				ID:            spanHexID(s.ID),
+				Name:          s.Name,
+				Timestamp:     s.Start.UnixNano() / 1000,
+				Duration:      micros(s),
+				LocalEndpoint: zipkinEndpoint{ServiceName: service},
+				Tags:          map[string]string{"thread.id": strconv.FormatInt(s.Goid, 10)},
+			}
+			if s.ParentID != 0 {
+				z.ParentID = spanHexID(s.ParentID)
+			}
+			if kind := spanKind(s); kind != spanInternal {
+				z.Kind = strings.ToUpper(kind)
+			}
+			for _, kv := range spanAttrs(s) {
+				z.Tags[kv[0]] = kv[1]
+			}
+			if msg, failed := spanError(s); failed {
+				z.Tags["error"] = msg
+			}
+			spans = append(spans, z)
+		}
+	}
+	payload, err := json.Marshal(spans)
+	return name, payload, err
+}
+
+// span 的时长，微秒，至少为 1，Zipkin 与 Jaeger 中 0 表示未结束
+func micros(s *Span) int64 {
+	if d := s.End.Sub(s.Start).Microseconds(); d > 0 {
+		return d
+	}
+	return 1
+}
@@ -0,0 +1,111 @@
+package instrument
+
+import (
+	"fmt"
+	"go/ast"
+	"path"
+	"sort"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// 创建引用导入包的标识符，实际名称在 resolveImports 中按文件确定
+func (i *InsPara) pkgIdent(pkgPath, name string) *ast.Ident {
+	ident := &ast.Ident{
+		Name: name,
+	}
+	i.pkgIdents[ident] = pkgPath
+	return ident
+}
+
+// 引用运行时包的标识符
+func (i *InsPara) runtimeIdent() *ast.Ident {
+	return i.pkgIdent(i.runtimePkg(), i.RuntimeName)
+}
+
+// 为改写文件补充导入：已导入的包复用其名称，否则合并进已有的 import 块，名称冲突时另选别名
+func (i *InsPara) resolveImports(filename string, file *rewrite) error {
+	used := make(map[string][]*ast.Ident)
+	ast.Inspect(file.astfile, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			if pkgPath, ok := i.pkgIdents[ident]; ok {
+				used[pkgPath] = append(used[pkgPath], ident)
+			}
+		}
+		return true
+	})
+
+	paths := make([]string, 0, len(used))
+	for pkgPath := range used {
+		paths = append(paths, pkgPath)
+	}
+	sort.Strings(paths)
+
+	taken := make(map[string]struct{})
+	for _, spec := range file.astfile.Imports {
+		if spec.Name != nil {
+			taken[spec.Name.Name] = struct{}{}
+		} else if p, err := strconv.Unquote(spec.Path.Value); err == nil {
+			taken[path.Base(p)] = struct{}{}
+		}
+	}
+
+	for _, pkgPath := range paths {
+		idents := used[pkgPath]
+		want := idents[0].Name
+
+		name, ok := existingImport(file.astfile, pkgPath, want)
+		if !ok {
+			name = i.freshName(filename, file, want, pkgPath, taken)
+			if name == "" {
+				return fmt.Errorf("%s: no free name to import %s", filename, pkgPath)
+			}
+			alias := name
+			if name == want && path.Base(pkgPath) == want {
+				alias = ""
+			}
+			astutil.AddNamedImport(i.Project.SsaProgram.Fset, file.astfile, alias, pkgPath)
+			taken[name] = struct{}{}
+		}
+
+		for _, ident := range idents {
+			ident.Name = name
+		}
+	}
+	return nil
+}
+
+// 文件中已有的可用导入，want 为该包的包名
+func existingImport(f *ast.File, pkgPath, want string) (string, bool) {
+	for _, spec := range f.Imports {
+		if p, err := strconv.Unquote(spec.Path.Value); err != nil || p != pkgPath {
+			continue
+		}
+		if spec.Name == nil {
+			return want, true
+		}
+		if spec.Name.Name != "_" && spec.Name.Name != "." {
+			return spec.Name.Name, true
+		}
+	}
+	return "", false
+}
+
+// 选出文件中未被占用的名称，依次尝试 want、want2、want3 ...
+func (i *InsPara) freshName(filename string, file *rewrite, want, pkgPath string, taken map[string]struct{}) string {
+	for n := 1; n < 100; n++ {
+		name := want
+		if n > 1 {
+			name = fmt.Sprintf("%s%d", want, n)
+		}
+		if _, ok := taken[name]; ok || i.isVarName(name) {
+			continue
+		}
+		if i.lookupFileScope(filename, file.pkg, name, pkgPath) != nil {
+			continue
+		}
+		return name
+	}
+	return ""
+}
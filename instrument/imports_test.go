@@ -0,0 +1,121 @@
+package instrument
+
+import (
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// 与运行时包同名的其他包
+const otherGoreport = `package goreport
+
+func Other() {}
+`
+
+// 预先存在的运行时包，插桩时被生成的运行时包覆盖，Span 两者都有
+const stubRuntime = `package goreport
+
+type Span struct{}
+`
+
+func TestResolveImports(t *testing.T) {
+	tests := []struct {
+		name    string
+		main    string
+		imports []string // 插桩后的导入
+		qual    string   // 插入代码引用运行时包所用的名称
+	}{
+		{
+			name:    "no imports",
+			main:    "package main\n\nfunc main() {}\n",
+			imports: []string{`"example.com/app/goreport"`},
+			qual:    "goreport",
+		},
+		{
+			name:    "merged into the import block",
+			main:    "package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc main() { fmt.Println() }\n",
+			imports: []string{`"example.com/app/goreport"`, `"fmt"`},
+			qual:    "goreport",
+		},
+		{
+			name:    "another package named goreport",
+			main:    "package main\n\nimport \"example.com/app/other/goreport\"\n\nfunc main() { goreport.Other() }\n",
+			imports: []string{`goreport2 "example.com/app/goreport"`, `"example.com/app/other/goreport"`},
+			qual:    "goreport2",
+		},
+		{
+			name:    "aliased other package",
+			main:    "package main\n\nimport goreport \"example.com/app/other/goreport\"\n\nfunc main() { goreport.Other() }\n",
+			imports: []string{`goreport2 "example.com/app/goreport"`, `goreport "example.com/app/other/goreport"`},
+			qual:    "goreport2",
+		},
+		{
+			name:    "package-level name goreport",
+			main:    "package main\n\nvar goreport = 1\n\nfunc main() { _ = goreport }\n",
+			imports: []string{`goreport2 "example.com/app/goreport"`},
+			qual:    "goreport2",
+		},
+		{
+			name:    "runtime already imported under an alias",
+			main:    "package main\n\nimport gr \"example.com/app/goreport\"\n\nvar _ *gr.Span\n\nfunc main() {}\n",
+			imports: []string{`gr "example.com/app/goreport"`},
+			qual:    "gr",
+		},
+		{
+			name:    "runtime dot-imported",
+			main:    "package main\n\nimport . \"example.com/app/goreport\"\n\nvar _ *Span\n\nfunc main() {}\n",
+			imports: []string{`"example.com/app/goreport"`, `. "example.com/app/goreport"`},
+			qual:    "goreport",
+		},
+		{
+			name:    "runtime blank-imported",
+			main:    "package main\n\nimport _ \"example.com/app/goreport\"\n\nfunc main() {}\n",
+			imports: []string{`"example.com/app/goreport"`, `_ "example.com/app/goreport"`},
+			qual:    "goreport",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeModule(t, map[string]string{
+				"main.go":              tt.main,
+				"other/goreport/gr.go": otherGoreport,
+				"goreport/span.go":     stubRuntime,
+			})
+			out, err := instrumentTree(t, root, func(i *InsPara) {
+				i.Detectors = []EntryDetector{mainDetector{}}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := readFile(t, filepath.Join(out, "main.go"))
+			f, err := parser.ParseFile(token.NewFileSet(), "main.go", src, parser.ImportsOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 按路径排序，同一路径再按名称
+			sort.Slice(f.Imports, func(a, b int) bool {
+				if f.Imports[a].Path.Value != f.Imports[b].Path.Value {
+					return f.Imports[a].Path.Value < f.Imports[b].Path.Value
+				}
+				return f.Imports[a].Name == nil
+			})
+			imports := []string{}
+			for _, spec := range f.Imports {
+				if spec.Name != nil {
+					imports = append(imports, spec.Name.Name+" "+spec.Path.Value)
+				} else {
+					imports = append(imports, spec.Path.Value)
+				}
+			}
+			if strings.Join(imports, "\n") != strings.Join(tt.imports, "\n") {
+				t.Errorf("got imports\n%s\nwant\n%s", strings.Join(imports, "\n"), strings.Join(tt.imports, "\n"))
+			}
+			if !strings.Contains(src, tt.qual+".StartMainMode()") {
+				t.Errorf("runtime not referred to as %s in\n%s", tt.qual, src)
+			}
+		})
+	}
+}
@@ -5,166 +5,338 @@ import (
 	"errors"
 	"fmt"
 	"go/ast"
-	"go/format"
 	"go/token"
+	"go/types"
+	"io"
 	"os"
 	"path/filepath"
 
+	"github.com/dave/dst/decorator"
+
 	"github.com/Shanjm/tracing-aspect/analysis"
 	"github.com/Shanjm/tracing-aspect/callgraph"
 	"github.com/Shanjm/tracing-aspect/log"
+	"github.com/Shanjm/tracing-aspect/selector"
 )
 
 const (
 	PackageName = "goreport"
 	ParentId    = "_parentId"
+	VarPrefix   = "_"
 )
 
 var (
 	ErrNotDir = errors.New("input project path is not dir")
 )
 
+// OutputMode 插桩结果的输出方式
+type OutputMode int
+
+const (
+	InPlace    OutputMode = iota // 直接覆盖源文件
+	OutputTree                   // 复制模块到输出目录，只改写副本
+	Overlay                      // 只在缓存目录生成改写文件与 go build -overlay 配置
+	Diff                         // 只输出改写前后的统一 diff，不写任何文件
+)
+
 // InsPara 插桩结构体
 type InsPara struct {
 	RootDir string
 	Project *analysis.Project
 	Calling callgraph.CallingMap
-
-	funcMap       map[string]struct{}           // 需要追踪的函数
-	rewriteMap    map[string]*rewrite           // 重写文件map
-	nodeInspected map[ast.Node]struct{}         // 已经访问过的节点
-	visited       map[*analysis.Member]struct{} // 已经访问过的函数
+	Entries []string        // 入口函数名正则，即 config 策略
+	Rules   []selector.Rule // 函数选择规则，选中的函数记录入参与返回值
+	Advice  string          // 通知包导入路径，其中的 Before/After/Around 织入选中的函数，见 aspect 包
+
+	Detectors []EntryDetector // 入口识别策略，按顺序取第一个识别出的，为空时见 entryDetectors
+
+	RuntimePkg     string // 运行时包导入路径，为空则为 RootPkg/goreport，模块外的路径需自行提供运行时包
+	RuntimeName    string // 运行时包名，也是改写文件中的导入名
+	ParentIdName   string // 改写函数中保存父协程 id 的变量名
+	VarPrefix      string // 插入的其他变量名的前缀，如 _arg_0、_ret_arg_0、_0、_md、_jp
+	Exporter       string // 运行时默认的导出方式，见 Exporters，为空则为 stderr，可由环境变量 TRACING_EXPORTER 覆盖
+	ExportFile     string // 运行时默认的导出文件，可由环境变量 TRACING_EXPORT_FILE 覆盖
+	ExportEndpoint string // 运行时默认的导出地址，如 OTLP/HTTP 的 collector，可由环境变量 TRACING_EXPORT_ENDPOINT 覆盖
+
+	Mode        OutputMode // 输出方式
+	OutputDir   string     // 输出目录，OutputTree 与 Overlay 模式下为空则使用临时目录
+	OverlayFile string     // overlay 配置文件，为空则写入 OutputDir/overlay.json
+	DiffOutput  io.Writer  // Diff 模式的输出，为空则为标准输出
+	SkipVerify  bool       // 跳过改写后的类型检查，检查失败时整批回滚
+	Tests       bool       // 同时插桩 _test.go，测试与基准测试函数作为入口
+
+	advice        *advice                            // 通知包中的通知函数
+	entries       map[*analysis.Member]EntryDetector // 入口函数及识别出它的策略
+	grpcServers   []*types.TypeName                  // 项目中的 gRPC 服务接口，nil 为尚未查找
+	mainMode      bool                               // main.main 是入口，os.Exit 需替换为运行时的 Exit
+	funcMap       map[string]struct{}                // 需要追踪的函数
+	pkgIdents     map[*ast.Ident]string              // 插入代码中引用导入包的标识符，value 为包路径
+	rewriteMap    map[string]*rewrite                // 重写文件map
+	nodeInspected map[ast.Node]struct{}              // 已经访问过的节点
+	preamble      map[*ast.BlockStmt]int             // 函数体开头已插入的语句数，如 go 语句的匿名函数中注册协程的语句
+	visited       map[*analysis.Member]struct{}      // 已经访问过的函数
 }
 
 // 重写结构体
 type rewrite struct {
-	astfile  *ast.File // 重写的文件
-	needGoId bool      // 是否需要获取id
+	astfile *ast.File                                // 重写的文件
+	decs    *decorator.Decorator                     // 改写前记录的注释与空行
+	pkg     *analysis.Package                        // 文件所属的包
+	vars    map[*analysis.Member]map[string]struct{} // 各函数中插入的变量名
+	exits   bool                                     // 替换过 os.Exit
 }
 
 // NewInstrument 返回一个插桩结构体
-func NewInstrument(root string) *InsPara {
+func NewInstrument(root string) (*InsPara, error) {
 	dir, err := os.Stat(root)
 	if !(err == nil && dir.IsDir()) {
-		log.Fatalln(ErrNotDir)
+		return nil, ErrNotDir
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, err
 	}
-	root, _ = filepath.Abs(root)
 	return &InsPara{
 		RootDir: root,
 
+		RuntimeName:  PackageName,
+		ParentIdName: ParentId,
+		VarPrefix:    VarPrefix,
+
+		entries:       make(map[*analysis.Member]EntryDetector),
 		funcMap:       make(map[string]struct{}),
+		pkgIdents:     make(map[*ast.Ident]string),
 		rewriteMap:    make(map[string]*rewrite),
 		nodeInspected: make(map[ast.Node]struct{}),
+		preamble:      make(map[*ast.BlockStmt]int),
 		visited:       make(map[*analysis.Member]struct{}),
-	}
+	}, nil
 }
 
 // Instrument 进行插桩
-func (i *InsPara) Instrument() {
-	i.parseProject()
+func (i *InsPara) Instrument() error {
+	if err := i.parseProject(); err != nil {
+		return err
+	}
+	if err := i.checkRuntimeConfig(); err != nil {
+		return err
+	}
 
-	for _, pkg := range i.Project.Pm {
+	detectors, err := i.entryDetectors()
+	if err != nil {
+		return err
+	}
+	if err := i.selectFuncs(); err != nil {
+		return err
+	}
+	if err := i.loadAdvice(); err != nil {
+		return err
+	}
+
+	// 先识别所有入口，作为其他入口的下游时也插入开始代码
+	for path, pkg := range i.Project.Pm {
+		if i.isAspectPkg(path) {
+			// 不对运行时包与通知包插桩
+			continue
+		}
 		for _, file := range pkg.Fm {
 			for _, fu := range file.FunMember {
-				log.Println(fmt.Sprintf("find the entry: %s", fu.Name))
-				i.instrument(fu, file.ParsedFile, true)
+				if entry := i.detectEntry(detectors, fu); entry != nil {
+					log.Println(fmt.Sprintf("find the entry: %s (%s)", fu.Name, entry.Name()))
+					i.entries[fu] = entry
+					i.mainMode = i.mainMode || isMainFunc(fu.Fun)
+				}
 			}
 		}
 	}
+	for fu, entry := range i.entries {
+		i.instrument(fu, i.Project.Pm[fu.Pkg.Pkg.Path()].Fm[fu.File].ParsedFile, entry)
+	}
 
-	i.rewrite()
+	return i.rewrite()
 }
 
-func (i *InsPara) parseProject() {
-	result, err := analysis.ParseProject(i.RootDir)
+func (i *InsPara) parseProject() error {
+	result, err := analysis.ParseProject(i.RootDir, i.Tests)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 	cm, err := callgraph.GenerateCallgraph(result)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 
 	i.Project = result
 	i.Calling = cm
 
 	log.Println(fmt.Sprintf("the root package: %s", i.Project.RootPkg))
+	return nil
+}
+
+// 根据选择规则与 //tracing:trace 确定需要追踪的函数，被 //tracing:ignore 排除的除外
+func (i *InsPara) selectFuncs() error {
+	for _, pkg := range i.Project.Pm {
+		for _, file := range pkg.Fm {
+			for _, fu := range file.FunMember {
+				if fu.Directives.Trace {
+					i.funcMap[fu.Name] = struct{}{}
+				}
+			}
+		}
+	}
+
+	if len(i.Rules) > 0 {
+		sel, err := selector.New(i.Rules)
+		if err != nil {
+			return err
+		}
+		res := sel.Select(i.Project)
+		for name := range res.Selected {
+			i.funcMap[name] = struct{}{}
+		}
+
+		buffer := bytes.NewBufferString("")
+		res.Report(buffer)
+		log.Println(buffer.String())
+	}
+
+	for _, pkg := range i.Project.Pm {
+		for _, file := range pkg.Fm {
+			for _, fu := range file.FunMember {
+				if i.Project.Ignored(fu) {
+					delete(i.funcMap, fu.Name)
+				}
+			}
+		}
+	}
+	return nil
 }
 
 // 重写文件
-func (i *InsPara) rewrite() {
+func (i *InsPara) rewrite() error {
+	srcs := make(map[string][]byte, len(i.rewriteMap))
 	for filename := range i.rewriteMap {
 		file := i.rewriteMap[filename]
 
-		i.modifyImport(file)
+		if err := i.checkCollision(file); err != nil {
+			return err
+		}
 
-		buffer := bytes.NewBufferString("")
-		if err := format.Node(buffer, token.NewFileSet(), file.astfile); err != nil {
-			log.Fatalln(err)
+		if file.exits {
+			removeUnusedImport(i.Project.SsaProgram.Fset, file.astfile, "os")
+		}
+		if err := i.resolveImports(filename, file); err != nil {
+			return err
+		}
+
+		src, err := printFile(i.Project.SsaProgram.Fset, file.astfile, file.decs)
+		if err != nil {
+			return fmt.Errorf("format %s: %w", filename, err)
+		}
+		srcs[filename] = src
+	}
+
+	if len(srcs) > 0 {
+		// 同时生成运行时包
+		files, err := i.runtimeFiles()
+		if err != nil {
+			return err
+		}
+		for filename, src := range files {
+			srcs[filename] = src
 		}
-		os.WriteFile(filename, buffer.Bytes(), 0644)
+		i.checkRuntimeDeps()
+	}
+
+	var rollback func() error
+	var err error
+	switch i.Mode {
+	case InPlace:
+		rollback, err = i.writeInPlace(srcs)
+	case OutputTree:
+		rollback, err = i.writeTree(srcs)
+	case Overlay:
+		rollback, err = i.writeOverlay(srcs)
+	case Diff:
+		err = i.writeDiff(srcs)
+	default:
+		return fmt.Errorf("unknown output mode %d", i.Mode)
 	}
+	if err == nil && !i.SkipVerify && len(srcs) > 0 {
+		err = i.verifyOutput(srcs)
+	}
+	if err != nil && rollback != nil {
+		// 整批回滚
+		if rerr := rollback(); rerr != nil {
+			return fmt.Errorf("%v, and rollback failed: %w", err, rerr)
+		}
+		log.Println("rolled back the instrumented files")
+	}
+	return err
 }
 
-// 修改import
-func (i *InsPara) modifyImport(file *rewrite) {
-	file.astfile.Decls = append([]ast.Decl{
-		&ast.GenDecl{
-			Tok: token.IMPORT,
-			Specs: []ast.Spec{
-				&ast.ImportSpec{
-					Name: &ast.Ident{
-						Name: PackageName,
-					},
-					Path: &ast.BasicLit{
-						Kind:  token.STRING,
-						Value: fmt.Sprintf("\"%s/%s\"", i.Project.RootPkg, PackageName),
-					},
-				},
-			},
-		},
-	}, file.astfile.Decls...)
-
-	if file.needGoId {
-		file.astfile.Decls = append([]ast.Decl{
-			&ast.GenDecl{
-				Tok: token.IMPORT,
-				Specs: []ast.Spec{
-					&ast.ImportSpec{
-						Path: &ast.BasicLit{
-							Kind:  token.STRING,
-							Value: "\"github.com/petermattis/goid\"",
-						},
-					},
-				},
-			},
-		}, file.astfile.Decls...)
+// 按输出方式对改写结果做类型检查
+func (i *InsPara) verifyOutput(srcs map[string][]byte) error {
+	modRoot, err := moduleRoot(i.RootDir)
+	if err != nil {
+		return err
+	}
+	switch i.Mode {
+	case OutputTree:
+		return i.verify(srcs, i.OutputDir, nil)
+	case Overlay, Diff:
+		return i.verify(srcs, modRoot, srcs)
+	default:
+		return i.verify(srcs, modRoot, nil)
 	}
 }
 
-func (i *InsPara) instrument(funcMember *analysis.Member, file *ast.File, isStart bool) {
+// 插桩函数及其下游，entry 为识别出入口的策略，非入口为 nil
+func (i *InsPara) instrument(funcMember *analysis.Member, file *ast.File, entry EntryDetector) {
 	if _, ok := i.visited[funcMember]; ok {
 		log.Println(funcMember.Name + " has visited")
 		return
 	}
+	if i.isAspectPkg(funcMember.Pkg.Pkg.Path()) {
+		return
+	}
+	if i.Project.Ignored(funcMember) {
+		// 被 //tracing:ignore 排除，不改写，但仍遍历下游
+		log.Println(funcMember.Name + " is ignored")
+		i.visited[funcMember] = struct{}{}
+		i.instrumentDownstream(funcMember)
+		return
+	}
+	if i.isInstrumented(funcMember) {
+		// 之前插桩过，不重复插入，但仍遍历下游以插桩新增的函数
+		log.Println(funcMember.Name + " has been instrumented before")
+		i.visited[funcMember] = struct{}{}
+		i.instrumentDownstream(funcMember)
+		return
+	}
 	log.Println(fmt.Sprintf("Start to instrument %s\n", funcMember.Name))
 
 	ast.Inspect(file, func(n ast.Node) bool {
 
-		if _, ok := i.nodeInspected[n]; n == nil || ok {
+		if n == nil {
 			return false
 		}
+		if _, ok := i.nodeInspected[n]; ok {
+			// 已改写的函数中仍可能有待插桩的匿名函数
+			return true
+		}
 
 		if funcMember.ComparePostion(funcMember.Fun.Prog.Fset.Position(n.Pos()),
 			funcMember.Fun.Prog.Fset.Position(n.End())) {
 
 			if _, ok := i.rewriteMap[funcMember.File]; !ok {
 				i.rewriteMap[funcMember.File] = &rewrite{
-					astfile:  file,
-					needGoId: false,
+					astfile: file,
+					decs:    decorate(i.Project.SsaProgram.Fset, file),
+					pkg:     i.Project.Pm[funcMember.Pkg.Pkg.Path()],
 				}
 			}
-			i.reconstrcut(funcMember, n, isStart)
+			i.reconstrcut(funcMember, n, entry)
 			i.nodeInspected[n] = struct{}{}
 			i.visited[funcMember] = struct{}{}
 			return false
@@ -173,18 +345,42 @@ func (i *InsPara) instrument(funcMember *analysis.Member, file *ast.File, isStar
 		return true
 	})
 
-	// 遍历下游
+	i.instrumentDownstream(funcMember)
+}
+
+// 遍历下游
+func (i *InsPara) instrumentDownstream(funcMember *analysis.Member) {
 	if down, ok := i.Calling[funcMember]; ok {
 		for _, d := range down {
-			i.instrument(d, i.Project.Pm[d.Pkg.Pkg.Path()].Fm[d.File].ParsedFile, false)
+			i.instrument(d, i.Project.Pm[d.Pkg.Pkg.Path()].Fm[d.File].ParsedFile, i.entries[d])
 		}
 	}
 }
 
+// 函数中是否已有插桩代码，即引用了运行时包
+func (i *InsPara) isInstrumented(funcMember *analysis.Member) bool {
+	info := i.Project.Info[funcMember.Pkg.Pkg]
+	if info == nil || funcMember.Node == nil {
+		return false
+	}
+
+	found := false
+	ast.Inspect(funcMember.Node, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			if pn, ok := info.Uses[ident].(*types.PkgName); ok && pn.Imported().Path() == i.runtimePkg() {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
 // 重造函数
-func (i *InsPara) reconstrcut(funcMember *analysis.Member, node ast.Node, isStart bool) {
+func (i *InsPara) reconstrcut(funcMember *analysis.Member, node ast.Node, entry EntryDetector) {
 	var bodyStmt *ast.BlockStmt
 	var funcType *ast.FuncType
+	var recv *ast.FieldList
 	switch x := node.(type) {
 	case *ast.FuncLit: // 匿名
 		funcType = x.Type
@@ -192,31 +388,45 @@ func (i *InsPara) reconstrcut(funcMember *analysis.Member, node ast.Node, isStar
 	case *ast.FuncDecl: // 非匿名
 		funcType = x.Type
 		bodyStmt = x.Body
+		recv = x.Recv
 	default:
 		return
 	}
 
 	zeroLineStmts := []ast.Stmt{}
-	if isStart {
-		zeroLineStmts = append(zeroLineStmts, i.getStartStmt()...)
-		zeroLineStmts = append(zeroLineStmts, i.getCopyStmt(funcMember)...)
+	if entry != nil {
+		zeroLineStmts = append(zeroLineStmts, i.getStartStmt(funcMember)...)
+		zeroLineStmts = append(zeroLineStmts, entry.Capture(i, funcMember, funcType)...)
 	}
 
-	if _, ok := i.funcMap[funcMember.Name]; ok {
+	_, traced := i.funcMap[funcMember.Name]
+	if traced {
 		// 是需要追踪的函数
-		zeroLineStmts = append(zeroLineStmts, i.getInputStmt(funcMember)...)
-		if funcType.Results != nil {
-			i.wrapperReturnStmt(bodyStmt, funcType)
-		}
+		zeroLineStmts = append(zeroLineStmts, i.getInputStmt(funcMember, recv, funcType)...)
 	}
 
-	if varNo := 0; i.detectGoStmt(bodyStmt, &varNo) {
+	if varNo := 0; i.detectGoStmt(funcMember, bodyStmt, &varNo) {
 		// 开启了新协程, 需要获取goid
-		zeroLineStmts = append(i.getParentIdStmt(), zeroLineStmts...)
-		i.rewriteMap[funcMember.File].needGoId = true
+		zeroLineStmts = append(i.getParentIdStmt(funcMember), zeroLineStmts...)
 	}
 
-	i.insertStmt(bodyStmt, zeroLineStmts, new(int))
+	// 放在已插入的语句之后，作为 go 语句的匿名函数需要先注册协程
+	index := i.preamble[bodyStmt]
+	i.insertStmt(bodyStmt, zeroLineStmts, &index)
+	i.preamble[bodyStmt] = index
+
+	if i.mainMode {
+		i.rewriteExit(funcMember, bodyStmt)
+	}
+	i.wrapCalls(funcMember, bodyStmt)
+
+	if traced && i.advice != nil {
+		i.weaveAdvice(funcMember, recv, funcType, bodyStmt)
+	}
+	// 织入通知后匿名返回值已在外层命名，记录的是通知处理后的返回值
+	if traced && funcType.Results != nil {
+		i.wrapperReturnStmt(funcMember, bodyStmt, funcType)
+	}
 }
 
 func (i *InsPara) insertStmt(body ast.Stmt, stmts []ast.Stmt, index *int) {
@@ -235,21 +445,14 @@ func (i *InsPara) insertStmt(body ast.Stmt, stmts []ast.Stmt, index *int) {
 }
 
 // 包装 return 语句
-func (i *InsPara) wrapperReturnStmt(bs *ast.BlockStmt, ft *ast.FuncType) {
+func (i *InsPara) wrapperReturnStmt(funcMember *analysis.Member, bs *ast.BlockStmt, ft *ast.FuncType) {
 	if len(ft.Results.List) == 0 {
 		// 没有返回参数
 		return
 	}
 
 	if len(ft.Results.List[0].Names) > 0 {
-		args := []ast.Expr{}
-		for _, l := range ft.Results.List {
-			for _, name := range l.Names {
-				args = append(args, &ast.Ident{
-					Name: name.Name,
-				})
-			}
-		}
+		// 具名返回值，在 defer 中记录
 		var deferStmt *ast.DeferStmt = &ast.DeferStmt{
 			Call: &ast.CallExpr{
 				Fun: &ast.FuncLit{
@@ -262,14 +465,12 @@ func (i *InsPara) wrapperReturnStmt(bs *ast.BlockStmt, ft *ast.FuncType) {
 							&ast.ExprStmt{
 								X: &ast.CallExpr{
 									Fun: &ast.SelectorExpr{
-										X: &ast.Ident{
-											Name: PackageName,
-										},
+										X: i.runtimeIdent(),
 										Sel: &ast.Ident{
-											Name: "ReportM",
+											Name: "ReportOutput",
 										},
 									},
-									Args: args,
+									Args: i.nameFields(funcMember, ft.Results, "ret_arg"),
 								},
 							},
 						},
@@ -278,47 +479,41 @@ func (i *InsPara) wrapperReturnStmt(bs *ast.BlockStmt, ft *ast.FuncType) {
 			},
 		}
 
-		bs.List = append([]ast.Stmt{deferStmt}, bs.List...)
+		// 作为开头插入的语句，在函数体其余部分之前
+		index := i.preamble[bs]
+		i.insertStmt(bs, []ast.Stmt{deferStmt}, &index)
+		i.preamble[bs] = index
 		return
 	}
 
 	ast.Inspect(bs, func(n ast.Node) bool {
+		if _, ok := n.(*ast.FuncLit); ok {
+			// 匿名函数中的 return 不属于当前函数
+			return false
+		}
 		rStmt, ok := n.(*ast.ReturnStmt)
 		if !ok {
 			return true
 		}
 
-		args := []ast.Expr{}
+		// 闭包使用具名返回值接收结果，nil 与无类型常量可以直接赋值
+		results := &ast.FieldList{}
 		retArgs := []ast.Expr{}
-
-		for rIndex := 0; rIndex < ft.Results.NumFields(); rIndex++ {
-			retArg := &ast.Ident{
-				Name: fmt.Sprintf("_ret_arg_%d", rIndex),
-			}
-
-			args = append(args, retArg)
-			retArgs = append(retArgs, retArg)
-		}
-
-		delete := []int{}
-		for rIndex, expr := range rStmt.Results {
-			if ident, ok := expr.(*ast.Ident); ok && ident.Name == "nil" {
-				retArgs[rIndex].(*ast.Ident).Name = "nil"
-				delete = append([]int{rIndex}, delete...)
-			}
-		}
-
-		for _, index := range delete {
-			args = append(args[:index], args[index+1:]...)
-			rStmt.Results = append(rStmt.Results[:index], rStmt.Results[index+1:]...)
+		for rIndex, field := range ft.Results.List {
+			name := i.varName(funcMember, "ret_arg_%d", rIndex)
+			results.List = append(results.List, &ast.Field{
+				Names: []*ast.Ident{{Name: name}},
+				Type:  field.Type,
+			})
+			retArgs = append(retArgs, &ast.Ident{
+				Name: name,
+			})
 		}
 
 		var insertStmt *ast.ExprStmt = &ast.ExprStmt{
 			X: &ast.CallExpr{
 				Fun: &ast.SelectorExpr{
-					X: &ast.Ident{
-						Name: PackageName,
-					},
+					X: i.runtimeIdent(),
 					Sel: &ast.Ident{
 						Name: "ReportOutput",
 					},
@@ -332,19 +527,17 @@ func (i *InsPara) wrapperReturnStmt(bs *ast.BlockStmt, ft *ast.FuncType) {
 				Fun: &ast.FuncLit{
 					Type: &ast.FuncType{
 						Params:  &ast.FieldList{},
-						Results: ft.Results,
+						Results: results,
 					},
 					Body: &ast.BlockStmt{
 						List: []ast.Stmt{
 							&ast.AssignStmt{
-								Lhs: args,
-								Tok: token.DEFINE,
+								Lhs: retArgs,
+								Tok: token.ASSIGN,
 								Rhs: rStmt.Results,
 							},
 							insertStmt,
-							&ast.ReturnStmt{
-								Results: retArgs,
-							},
+							&ast.ReturnStmt{},
 						},
 					},
 				},
@@ -354,8 +547,32 @@ func (i *InsPara) wrapperReturnStmt(bs *ast.BlockStmt, ft *ast.FuncType) {
 	})
 }
 
+// 返回参数列表中各参数的标识符，匿名或空白标识符的参数会被命名为 VarPrefix+prefix_序号
+func (i *InsPara) nameFields(funcMember *analysis.Member, fl *ast.FieldList, prefix string) []ast.Expr {
+	if fl == nil {
+		return nil
+	}
+	idents := []ast.Expr{}
+	index := 0
+	for _, field := range fl.List {
+		if len(field.Names) == 0 {
+			field.Names = []*ast.Ident{{Name: i.varName(funcMember, "%s_%d", prefix, index)}}
+		}
+		for _, name := range field.Names {
+			if name.Name == "_" {
+				name.Name = i.varName(funcMember, "%s_%d", prefix, index)
+			}
+			idents = append(idents, &ast.Ident{
+				Name: name.Name,
+			})
+			index++
+		}
+	}
+	return idents
+}
+
 // 检查 go 语句
-func (i *InsPara) detectGoStmt(body ast.Stmt, varNo *int) (containGo bool) {
+func (i *InsPara) detectGoStmt(funcMember *analysis.Member, body ast.Stmt, varNo *int) (containGo bool) {
 	var stmts *[]ast.Stmt
 	switch typ := body.(type) {
 	case *ast.BlockStmt:
@@ -372,45 +589,48 @@ func (i *InsPara) detectGoStmt(body ast.Stmt, varNo *int) (containGo bool) {
 		switch s := (*stmts)[index].(type) {
 		case *ast.GoStmt:
 			containGo = true
-			i.handleGoStmt(s, body, &index, varNo)
+			i.handleGoStmt(funcMember, s, body, &index, varNo)
 		case *ast.IfStmt:
-			containGo = i.detectGoStmt(s.Body, varNo) || containGo
+			containGo = i.detectGoStmt(funcMember, s.Body, varNo) || containGo
 		case *ast.ForStmt:
-			containGo = i.detectGoStmt(s.Body, varNo) || containGo
+			containGo = i.detectGoStmt(funcMember, s.Body, varNo) || containGo
 		case *ast.RangeStmt:
-			containGo = i.detectGoStmt(s.Body, varNo) || containGo
+			containGo = i.detectGoStmt(funcMember, s.Body, varNo) || containGo
 		case *ast.SelectStmt:
-			containGo = i.detectGoStmt(s.Body, varNo) || containGo
+			containGo = i.detectGoStmt(funcMember, s.Body, varNo) || containGo
 		case *ast.SwitchStmt:
-			containGo = i.detectGoStmt(s.Body, varNo) || containGo
+			containGo = i.detectGoStmt(funcMember, s.Body, varNo) || containGo
 		case *ast.TypeSwitchStmt:
-			containGo = i.detectGoStmt(s.Body, varNo) || containGo
+			containGo = i.detectGoStmt(funcMember, s.Body, varNo) || containGo
 		case *ast.CommClause:
 			if s.Body == nil {
 				continue
 			}
-			containGo = i.detectGoStmt(s, varNo) || containGo
+			containGo = i.detectGoStmt(funcMember, s, varNo) || containGo
 		case *ast.CaseClause:
 			if s.Body == nil {
 				continue
 			}
-			containGo = i.detectGoStmt(s, varNo) || containGo
+			containGo = i.detectGoStmt(funcMember, s, varNo) || containGo
 		}
 	}
 	return
 }
 
-func (i *InsPara) handleGoStmt(s *ast.GoStmt, bodyStmt ast.Stmt, index, varNo *int) {
-	i.insertIncreaseStmt(bodyStmt, index)
+func (i *InsPara) handleGoStmt(funcMember *analysis.Member, s *ast.GoStmt, bodyStmt ast.Stmt, index, varNo *int) {
+	// go 语句处的 span 作为子协程的父 span
+	span := i.varName(funcMember, "span%d", *varNo)
+	(*varNo)++
+	i.insertIncreaseStmt(bodyStmt, index, span)
 
 	if funclit, ok := s.Call.Fun.(*ast.FuncLit); ok {
-		i.insertRegistStmt(funclit.Body)
+		i.insertRegistStmt(funclit.Body, span)
 	} else {
 		args := []ast.Expr{}
 		if s.Call.Args != nil && len(s.Call.Args) > 0 {
 			for range s.Call.Args {
 				args = append(args, &ast.Ident{
-					Name: fmt.Sprintf("_%d", *varNo),
+					Name: i.varName(funcMember, "%d", *varNo),
 				})
 				(*varNo)++
 			}
@@ -422,20 +642,26 @@ func (i *InsPara) handleGoStmt(s *ast.GoStmt, bodyStmt ast.Stmt, index, varNo *i
 			i.nodeInspected[assignStmt] = struct{}{}
 			i.insertStmt(bodyStmt, []ast.Stmt{assignStmt}, index)
 		}
-		i.insertWraperStmt(s, args)
+		i.insertWraperStmt(s, args, span)
 	}
 }
 
-// waitgroup 自增
-func (i *InsPara) insertIncreaseStmt(bodyStmt ast.Stmt, index *int) {
-	var insertStmt *ast.ExprStmt = &ast.ExprStmt{
-		X: &ast.CallExpr{
-			Fun: &ast.SelectorExpr{
-				X: &ast.Ident{
-					Name: PackageName,
-				},
-				Sel: &ast.Ident{
-					Name: "IncreaseWG",
+// waitgroup 自增，同时取得当前的 span 存入 span 变量
+func (i *InsPara) insertIncreaseStmt(bodyStmt ast.Stmt, index *int, span string) {
+	var insertStmt *ast.AssignStmt = &ast.AssignStmt{
+		Lhs: []ast.Expr{
+			&ast.Ident{
+				Name: span,
+			},
+		},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{
+			&ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X: i.runtimeIdent(),
+					Sel: &ast.Ident{
+						Name: "IncreaseWG",
+					},
 				},
 			},
 		},
@@ -444,21 +670,22 @@ func (i *InsPara) insertIncreaseStmt(bodyStmt ast.Stmt, index *int) {
 	i.insertStmt(bodyStmt, []ast.Stmt{insertStmt}, index)
 }
 
-// 注册子 goroutine 语句
-func (i *InsPara) insertRegistStmt(body ast.Stmt) {
+// 注册子 goroutine 语句，放在匿名函数最前面，之前已插入的语句顺延
+func (i *InsPara) insertRegistStmt(body *ast.BlockStmt, span string) {
 	var insertStmt *ast.ExprStmt = &ast.ExprStmt{
 		X: &ast.CallExpr{
 			Fun: &ast.SelectorExpr{
-				X: &ast.Ident{
-					Name: PackageName,
-				},
+				X: i.runtimeIdent(),
 				Sel: &ast.Ident{
 					Name: "RegisterChildrenId",
 				},
 			},
 			Args: []ast.Expr{
 				&ast.Ident{
-					Name: ParentId,
+					Name: i.ParentIdName,
+				},
+				&ast.Ident{
+					Name: span,
 				},
 			},
 		},
@@ -467,9 +694,7 @@ func (i *InsPara) insertRegistStmt(body ast.Stmt) {
 	var deferStmt *ast.DeferStmt = &ast.DeferStmt{
 		Call: &ast.CallExpr{
 			Fun: &ast.SelectorExpr{
-				X: &ast.Ident{
-					Name: PackageName,
-				},
+				X: i.runtimeIdent(),
 				Sel: &ast.Ident{
 					Name: "CloseGoRoutine",
 				},
@@ -478,10 +703,11 @@ func (i *InsPara) insertRegistStmt(body ast.Stmt) {
 	}
 
 	i.insertStmt(body, []ast.Stmt{deferStmt, insertStmt}, new(int))
+	i.preamble[body] += 2
 }
 
 // 为 go 语句增加 wrapper 函数
-func (i *InsPara) insertWraperStmt(stmt *ast.GoStmt, args []ast.Expr) {
+func (i *InsPara) insertWraperStmt(stmt *ast.GoStmt, args []ast.Expr, span string) {
 	stmt.Call.Args = args // 改变原语句参数
 	stmt.Call = &ast.CallExpr{
 		Fun: &ast.FuncLit{
@@ -498,5 +724,5 @@ func (i *InsPara) insertWraperStmt(stmt *ast.GoStmt, args []ast.Expr) {
 			},
 		},
 	}
-	i.insertRegistStmt(stmt.Call.Fun.(*ast.FuncLit).Body)
+	i.insertRegistStmt(stmt.Call.Fun.(*ast.FuncLit).Body, span)
 }
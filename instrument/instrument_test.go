@@ -0,0 +1,71 @@
+package instrument
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 测试模块依赖运行时包所需的 goid，与本模块的版本一致
+const (
+	testGoMod = `module example.com/app
+
+go 1.16
+
+require github.com/petermattis/goid v0.0.0-20230518223814-80aa455d8761
+`
+	testGoSum = `github.com/petermattis/goid v0.0.0-20230518223814-80aa455d8761 h1:W04oB3d0J01W5jgYRGKsV8LCM6g9EkCvPkZcmFuy0OE=
+github.com/petermattis/goid v0.0.0-20230518223814-80aa455d8761/go.mod h1:pxMtw7cyUw6B2bRH0ZBANSPg+AoSud1I1iyJHI69jH4=
+`
+)
+
+// 在临时目录中生成模块 example.com/app，files 的 key 为相对模块根目录的路径
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	all := map[string]string{"go.mod": testGoMod, "go.sum": testGoSum}
+	for name, src := range files {
+		all[name] = src
+	}
+	for name, src := range all {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// 以 Diff 模式插桩模块并做类型检查，configure 可调整插桩参数，返回 diff
+func instrumentDiff(t *testing.T, root string, configure func(i *InsPara)) (string, error) {
+	t.Helper()
+	i, err := NewInstrument(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	i.Mode, i.DiffOutput = Diff, out
+	if configure != nil {
+		configure(i)
+	}
+	err = i.Instrument()
+	return out.String(), err
+}
+
+// 以 OutputTree 模式插桩模块到临时目录并做类型检查，返回输出目录
+func instrumentTree(t *testing.T, root string, configure func(i *InsPara)) (string, error) {
+	t.Helper()
+	i, err := NewInstrument(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Mode, i.OutputDir = OutputTree, t.TempDir()
+	if configure != nil {
+		configure(i)
+	}
+	return i.OutputDir, i.Instrument()
+}
@@ -0,0 +1,163 @@
+package instrument
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+)
+
+var (
+	ErrNoRuntimePkg = errors.New("no main package found, set the runtime package path explicitly")
+)
+
+// 检查运行时包配置
+func (i *InsPara) checkRuntimeConfig() error {
+	if i.RuntimePkg == "" && i.Project.RootPkg == "" {
+		return ErrNoRuntimePkg
+	}
+	for _, name := range []string{i.RuntimeName, i.ParentIdName} {
+		if !token.IsIdentifier(name) || name == "_" {
+			return fmt.Errorf("%q is not a valid identifier", name)
+		}
+	}
+	if !token.IsIdentifier(i.VarPrefix) {
+		return fmt.Errorf("variable prefix %q is not a valid identifier", i.VarPrefix)
+	}
+	if i.RuntimeName == i.ParentIdName {
+		return fmt.Errorf("runtime name and parent id name are both %q", i.RuntimeName)
+	}
+	if i.isVarName(i.RuntimeName) {
+		return fmt.Errorf("runtime name %q starts with the variable prefix %q", i.RuntimeName, i.VarPrefix)
+	}
+	if i.Exporter == "" {
+		return nil
+	}
+	for _, e := range Exporters {
+		if e == i.Exporter {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown exporter %q, expected one of %s", i.Exporter, strings.Join(Exporters, ", "))
+}
+
+// 插入到函数中的变量名 VarPrefix+format，记录下来输出前检查冲突
+func (i *InsPara) varName(funcMember *analysis.Member, format string, args ...interface{}) string {
+	name := i.VarPrefix + fmt.Sprintf(format, args...)
+	i.declare(funcMember, name)
+	return name
+}
+
+// 记录插入到函数中的变量名
+func (i *InsPara) declare(funcMember *analysis.Member, name string) {
+	file, ok := i.rewriteMap[funcMember.File]
+	if !ok {
+		return
+	}
+	if file.vars == nil {
+		file.vars = make(map[*analysis.Member]map[string]struct{})
+	}
+	if file.vars[funcMember] == nil {
+		file.vars[funcMember] = make(map[string]struct{})
+	}
+	file.vars[funcMember][name] = struct{}{}
+}
+
+// 是否可能是插入的变量名
+func (i *InsPara) isVarName(name string) bool {
+	return name == i.ParentIdName || strings.HasPrefix(name, i.VarPrefix)
+}
+
+// 检查插入的变量是否与改写函数中已有的标识符冲突，导入名冲突时会另选别名
+func (i *InsPara) checkCollision(file *rewrite) error {
+	funcs := make([]*analysis.Member, 0, len(file.vars))
+	for fun := range file.vars {
+		funcs = append(funcs, fun)
+	}
+	sort.Slice(funcs, func(a, b int) bool { return funcs[a].Name < funcs[b].Name })
+	for _, fun := range funcs {
+		names := make([]string, 0, len(file.vars[fun]))
+		for name := range file.vars[fun] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if obj := i.lookupFuncScope(fun, name); obj != nil {
+				return fmt.Errorf("%s: %q is already used at %s, configure another name or prefix for the instrumentation",
+					fun.Name, name, i.Project.SsaProgram.Fset.Position(obj.Pos()))
+			}
+		}
+	}
+	return nil
+}
+
+// 在包作用域与文件中查找同名对象，对 path 自身的导入除外
+func (i *InsPara) lookupFileScope(filename string, pkg *analysis.Package, name, path string) types.Object {
+	tpkg := pkg.Pkg.Pkg
+	if obj := tpkg.Scope().Lookup(name); obj != nil {
+		return obj
+	}
+
+	info := i.Project.Info[tpkg]
+	if info == nil {
+		return nil
+	}
+	fset := i.Project.SsaProgram.Fset
+	for ident, obj := range info.Defs {
+		if obj == nil || ident.Name != name || fset.Position(ident.Pos()).Filename != filename {
+			continue
+		}
+		if pn, ok := obj.(*types.PkgName); ok && pn.Imported().Path() == path {
+			continue
+		}
+		return obj
+	}
+	for node, obj := range info.Implicits {
+		pn, ok := obj.(*types.PkgName)
+		if !ok || pn.Name() != name || fset.Position(node.Pos()).Filename != filename {
+			continue
+		}
+		if pn.Imported().Path() != path {
+			return obj
+		}
+	}
+	return nil
+}
+
+// 在函数内查找同名的声明，以及引用的外部同名对象，字段与方法除外
+func (i *InsPara) lookupFuncScope(fun *analysis.Member, name string) types.Object {
+	info := i.Project.Info[fun.Pkg.Pkg]
+	if info == nil || fun.Node == nil {
+		return nil
+	}
+	inFunc := func(ident *ast.Ident) bool {
+		return ident.Name == name && fun.Node.Pos() <= ident.Pos() && ident.Pos() < fun.Node.End()
+	}
+	for ident, obj := range info.Defs {
+		if obj != nil && inFunc(ident) {
+			return obj
+		}
+	}
+	for ident, obj := range info.Uses {
+		if !inFunc(ident) {
+			continue
+		}
+		switch x := obj.(type) {
+		case *types.Var:
+			if x.IsField() {
+				continue
+			}
+		case *types.Func:
+			if x.Type().(*types.Signature).Recv() != nil {
+				continue
+			}
+		}
+		return obj
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package instrument
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Shanjm/tracing-aspect/log"
+)
+
+var (
+	ErrNoModule = errors.New("go.mod not found above the project path")
+)
+
+// 覆盖源文件，先在清单中记录并备份原内容，以便 Restore
+// 返回的回滚函数将本批文件与清单恢复到写入前的状态
+func (i *InsPara) writeInPlace(srcs map[string][]byte) (func() error, error) {
+	modRoot, err := moduleRoot(i.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	m, err := loadManifest(modRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	// 记录写入前的状态
+	prevManifest, err := os.ReadFile(manifestPath(modRoot))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	prevEntries := make(map[string]struct{}, len(m.Files))
+	for _, e := range m.Files {
+		prevEntries[e.Path] = struct{}{}
+	}
+	prev := make(map[string][]byte, len(srcs))
+	for filename := range srcs {
+		ori, err := os.ReadFile(filename)
+		if err == nil {
+			prev[filename] = ori
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	rollback := func() error {
+		for filename := range srcs {
+			if ori, ok := prev[filename]; ok {
+				if err := os.WriteFile(filename, ori, 0644); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			os.Remove(filepath.Dir(filename))
+		}
+		if prevManifest == nil {
+			return os.RemoveAll(filepath.Join(modRoot, ManifestDir))
+		}
+		for _, e := range m.Files {
+			if _, ok := prevEntries[e.Path]; !ok && e.Backup != "" {
+				os.Remove(filepath.Join(modRoot, ManifestDir, e.Backup))
+			}
+		}
+		return os.WriteFile(manifestPath(modRoot), prevManifest, 0644)
+	}
+
+	for filename, src := range srcs {
+		if err := m.record(modRoot, filename, src); err != nil {
+			return rollback, err
+		}
+	}
+	if err := m.save(modRoot); err != nil {
+		return rollback, err
+	}
+
+	for filename, src := range srcs {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return rollback, err
+		}
+		if err := os.WriteFile(filename, src, 0644); err != nil {
+			return rollback, err
+		}
+	}
+	return rollback, nil
+}
+
+// 复制整个模块到输出目录，再将插桩后的文件写入副本
+// 返回的回滚函数将副本中的改写文件恢复为原内容，并删除新建的文件
+func (i *InsPara) writeTree(srcs map[string][]byte) (func() error, error) {
+	modRoot, err := moduleRoot(i.RootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	out, temp, err := i.outputDir()
+	if err != nil {
+		return nil, err
+	}
+	if out == modRoot {
+		return nil, fmt.Errorf("output dir %s is the module itself", out)
+	}
+	// 新建的临时目录整个删除
+	removeTemp := func() error {
+		return os.RemoveAll(out)
+	}
+
+	if err := copyTree(modRoot, out); err != nil {
+		if temp {
+			return removeTemp, err
+		}
+		return nil, err
+	}
+
+	targets := make(map[string]string, len(srcs))
+	for filename := range srcs {
+		rel, err := filepath.Rel(modRoot, filename)
+		if err != nil {
+			return nil, err
+		}
+		targets[filename] = filepath.Join(out, rel)
+	}
+	rollback := removeTemp
+	if !temp {
+		rollback = func() error {
+			for filename, target := range targets {
+				info, err := os.Stat(filename)
+				if os.IsNotExist(err) {
+					if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+						return err
+					}
+					os.Remove(filepath.Dir(target))
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				if err := copyFile(filename, target, info.Mode().Perm()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	for filename, src := range srcs {
+		target := targets[filename]
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return rollback, err
+		}
+		if err := os.WriteFile(target, src, 0644); err != nil {
+			return rollback, err
+		}
+	}
+	log.Println(fmt.Sprintf("write the instrumented module to %s", out))
+	return rollback, nil
+}
+
+// overlay 配置，格式见 go help build 中的 -overlay
+type overlayJSON struct {
+	Replace map[string]string
+}
+
+// 将改写文件写入缓存目录，生成 overlay 配置，源码保持不变
+// 返回的回滚函数删除写入的缓存文件与 overlay 配置
+func (i *InsPara) writeOverlay(srcs map[string][]byte) (func() error, error) {
+	modRoot, err := moduleRoot(i.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	cache, temp, err := i.outputDir()
+	if err != nil {
+		return nil, err
+	}
+	if i.OverlayFile == "" {
+		i.OverlayFile = filepath.Join(cache, "overlay.json")
+	}
+
+	overlay := overlayJSON{Replace: make(map[string]string, len(srcs))}
+	rollback := func() error {
+		if temp {
+			return os.RemoveAll(cache)
+		}
+		for _, target := range overlay.Replace {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Remove(i.OverlayFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	for filename, src := range srcs {
+		rel, err := filepath.Rel(modRoot, filename)
+		if err != nil {
+			return rollback, err
+		}
+		target := filepath.Join(cache, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return rollback, err
+		}
+		if err := os.WriteFile(target, src, 0644); err != nil {
+			return rollback, err
+		}
+		overlay.Replace[filename] = target
+	}
+
+	data, err := json.MarshalIndent(overlay, "", "\t")
+	if err != nil {
+		return rollback, err
+	}
+	if err := os.WriteFile(i.OverlayFile, data, 0644); err != nil {
+		return rollback, err
+	}
+	log.Println(fmt.Sprintf("write the overlay to %s", i.OverlayFile))
+	return rollback, nil
+}
+
+// 输出目录的绝对路径，未指定则创建临时目录，temp 为是否新建了临时目录
+func (i *InsPara) outputDir() (out string, temp bool, err error) {
+	if i.OutputDir == "" {
+		dir, err := os.MkdirTemp("", "tracing-aspect-")
+		if err != nil {
+			return "", false, err
+		}
+		i.OutputDir, temp = dir, true
+	}
+	out, err = filepath.Abs(i.OutputDir)
+	if err != nil {
+		return "", temp, err
+	}
+	i.OutputDir = out
+	return out, temp, nil
+}
+
+// 向上查找 go.mod 所在目录
+func moduleRoot(dir string) (string, error) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ErrNoModule
+		}
+		dir = parent
+	}
+}
+
+// 复制目录，跳过 .git 与输出目录本身
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			if info.Name() == ".git" || path == dst {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,223 @@
+package instrument
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Shanjm/tracing-aspect/log"
+)
+
+// ManifestDir 插桩清单目录，位于模块根目录下，以 . 开头不会被 go 命令当作包
+const ManifestDir = ".tracing-aspect"
+
+var (
+	ErrNoManifest = errors.New("no instrumentation manifest found")
+)
+
+// 插桩清单
+type manifest struct {
+	Files []*manifestEntry `json:"files"`
+}
+
+// 被改写的文件
+type manifestEntry struct {
+	Path             string      `json:"path"`              // 相对模块根目录
+	Created          bool        `json:"created"`           // 插桩新建的文件，恢复时删除
+	OriginalHash     string      `json:"original_hash"`     // 插桩前内容的 sha256
+	InstrumentedHash string      `json:"instrumented_hash"` // 插桩后内容的 sha256
+	Backup           string      `json:"backup"`            // 插桩前内容的备份，相对清单目录
+	Mode             os.FileMode `json:"mode"`              // 插桩前的权限，恢复时还原
+	Stale            bool        `json:"stale,omitempty"`   // 插桩后被修改过又再次插桩，备份中没有这些修改，拒绝恢复
+}
+
+func manifestPath(root string) string {
+	return filepath.Join(root, ManifestDir, "manifest.json")
+}
+
+func hashOf(src []byte) string {
+	sum := sha256.Sum256(src)
+	return hex.EncodeToString(sum[:])
+}
+
+// 读取清单，不存在则返回空清单
+func loadManifest(root string) (*manifest, error) {
+	data, err := os.ReadFile(manifestPath(root))
+	if os.IsNotExist(err) {
+		return &manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", manifestPath(root), err)
+	}
+	return m, nil
+}
+
+func (m *manifest) save(root string) error {
+	sort.Slice(m.Files, func(a, b int) bool {
+		return m.Files[a].Path < m.Files[b].Path
+	})
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(root, ManifestDir), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath(root), data, 0644)
+}
+
+func (m *manifest) find(path string) *manifestEntry {
+	for _, e := range m.Files {
+		if e.Path == path {
+			return e
+		}
+	}
+	return nil
+}
+
+// 记录即将被覆盖的文件并备份原内容，再次插桩时保留最初的备份；
+// 已记录的文件在插桩后又被修改过时备份不再是改写前的内容，标记为过期
+func (m *manifest) record(root, filename string, src []byte) error {
+	rel, err := filepath.Rel(root, filename)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("%s is outside the module %s", filename, root)
+	}
+
+	ori, err := os.ReadFile(filename)
+	created := os.IsNotExist(err)
+	if err != nil && !created {
+		return err
+	}
+
+	if e := m.find(rel); e != nil {
+		switch {
+		case e.Created, !created && hashOf(ori) == e.InstrumentedHash, !created && hashOf(ori) == e.OriginalHash:
+			// 新建的文件恢复时删除即可；仍为上次插桩后或插桩前的内容时备份依然有效
+		default:
+			if !e.Stale {
+				log.Println(fmt.Sprintf("%s was edited after instrumentation, it will not be restored", rel))
+			}
+			e.Stale = true
+		}
+		e.InstrumentedHash = hashOf(src)
+		return nil
+	}
+
+	e := &manifestEntry{
+		Path:             rel,
+		Created:          created,
+		InstrumentedHash: hashOf(src),
+	}
+	if !created {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		e.Mode = fi.Mode().Perm()
+		e.OriginalHash = hashOf(ori)
+		e.Backup = filepath.Join("backup", rel)
+		backup := filepath.Join(root, ManifestDir, e.Backup)
+		if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(backup, ori, 0644); err != nil {
+			return err
+		}
+	}
+	m.Files = append(m.Files, e)
+	return nil
+}
+
+// Restore 根据清单恢复被插桩改写的文件，插桩后又被修改过的文件会导致整体拒绝恢复
+// root 为项目根目录，清单从其所在模块的根目录读取
+func Restore(root string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	root, err = moduleRoot(root)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(manifestPath(root)); os.IsNotExist(err) {
+		return ErrNoManifest
+	}
+	m, err := loadManifest(root)
+	if err != nil {
+		return err
+	}
+
+	// 先全部检查，再统一恢复
+	edited := []string{}
+	pending := []*manifestEntry{}
+	backups := make(map[*manifestEntry][]byte)
+	for _, e := range m.Files {
+		cur, err := os.ReadFile(filepath.Join(root, e.Path))
+		if os.IsNotExist(err) && e.Created {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if e.Stale {
+			edited = append(edited, e.Path)
+			continue
+		}
+		switch hashOf(cur) {
+		case e.InstrumentedHash:
+			pending = append(pending, e)
+			if e.Created {
+				continue
+			}
+			ori, err := os.ReadFile(filepath.Join(root, ManifestDir, e.Backup))
+			if err != nil {
+				return err
+			}
+			if hashOf(ori) != e.OriginalHash {
+				return fmt.Errorf("backup of %s is corrupted", e.Path)
+			}
+			backups[e] = ori
+		case e.OriginalHash:
+			// 已经是原内容
+		default:
+			edited = append(edited, e.Path)
+		}
+	}
+	if len(edited) > 0 {
+		return fmt.Errorf("refuse to restore, files edited after instrumentation: %s", strings.Join(edited, ", "))
+	}
+
+	for _, e := range pending {
+		filename := filepath.Join(root, e.Path)
+		if e.Created {
+			if err := os.Remove(filename); err != nil {
+				return err
+			}
+			// 顺带删除因此变空的目录
+			os.Remove(filepath.Dir(filename))
+			continue
+		}
+		if err := os.WriteFile(filename, backups[e], 0644); err != nil {
+			return err
+		}
+		if err := os.Chmod(filename, e.Mode); err != nil {
+			return err
+		}
+		log.Println(fmt.Sprintf("restore %s", e.Path))
+	}
+
+	return os.RemoveAll(filepath.Join(root, ManifestDir))
+}
@@ -0,0 +1,108 @@
+package instrument
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestore(t *testing.T) {
+	const (
+		original     = "package app\n"
+		instrumented = "package app\n\n// instrumented\n"
+		again        = "package app\n\n// instrumented again\n"
+	)
+	tests := []struct {
+		name string
+		// 第一次插桩后、再次插桩前对文件的修改，为空则不修改
+		edit string
+		// 是否再次插桩
+		again bool
+		// 恢复应报的错误，为空则成功
+		restoreErr string
+	}{
+		{name: "once"},
+		{name: "twice", again: true},
+		{name: "reverted by hand", edit: original, again: true},
+		{name: "edited then instrumented", edit: "package app\n\nvar edited int\n", again: true, restoreErr: "refuse to restore"},
+		{name: "edited then restored", edit: "package app\n\nvar edited int\n", restoreErr: "refuse to restore"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFile(t, filepath.Join(root, "go.mod"), "module example.com/app\n", 0644)
+			app := filepath.Join(root, "app.go")
+			writeFile(t, app, original, 0755)
+			created := filepath.Join(root, "goreport", "config.go")
+			i := &InsPara{RootDir: root}
+
+			if _, err := i.writeInPlace(map[string][]byte{app: []byte(instrumented), created: []byte("package goreport\n")}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != "" {
+				writeFile(t, app, tt.edit, 0644)
+			}
+			if tt.again {
+				if _, err := i.writeInPlace(map[string][]byte{app: []byte(again)}); err != nil {
+					t.Fatalf("instrument again: %v", err)
+				}
+			}
+
+			err := Restore(root)
+			if !matchErr(err, tt.restoreErr) {
+				t.Fatalf("restore: got %v, want %q", err, tt.restoreErr)
+			}
+			if err != nil {
+				want := tt.edit
+				if tt.again {
+					want = again
+				}
+				if got := readFile(t, app); got != want {
+					t.Errorf("refused restore changed the file to %q", got)
+				}
+				return
+			}
+			if got := readFile(t, app); got != original {
+				t.Errorf("restored %q, want %q", got, original)
+			}
+			if fi, err := os.Stat(app); err != nil {
+				t.Error(err)
+			} else if fi.Mode().Perm() != 0755 {
+				t.Errorf("restored mode %v, want 0755", fi.Mode().Perm())
+			}
+			for _, path := range []string{created, filepath.Dir(created), filepath.Join(root, ManifestDir)} {
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s is left after restore", path)
+				}
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, content string, perm os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// err 是否为期望的错误，want 为空时期望没有错误
+func matchErr(err error, want string) bool {
+	if want == "" {
+		return err == nil
+	}
+	return err != nil && strings.Contains(err.Error(), want)
+}
@@ -0,0 +1,144 @@
+package instrument
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Shanjm/tracing-aspect/log"
+)
+
+// 运行时包源码，与 goreport 目录保持同步
+//
+//go:embed goreport/*.go
+var runtimeFS embed.FS
+
+const (
+	runtimeSrcDir = "goreport"                    // 运行时包源码目录
+	goidModule    = "github.com/petermattis/goid" // 运行时包依赖的第三方模块
+)
+
+// Exporters 运行时内置的导出方式
+var Exporters = []string{"stderr", "stdout", "jsonl", "memory", "otlp", "zipkin", "jaeger", "chrome", "none"}
+
+// 生成的运行时配置文件及其中的变量
+const (
+	runtimeConfigFile    = "config.go"
+	configExporter       = "configExporter"
+	configExportFile     = "configExportFile"
+	configExportEndpoint = "configExportEndpoint"
+	configOwnSignals     = "configOwnSignals"
+)
+
+// 运行时包导入路径
+func (i *InsPara) runtimePkg() string {
+	if i.RuntimePkg != "" {
+		return i.RuntimePkg
+	}
+	return fmt.Sprintf("%s/%s", i.Project.RootPkg, PackageName)
+}
+
+// 运行时包所在目录，不在模块内时返回 false
+func (i *InsPara) runtimeDir() (string, bool) {
+	pkg := i.runtimePkg()
+	if i.Project.Module == "" || !strings.HasPrefix(pkg, i.Project.Module+"/") {
+		return "", false
+	}
+	rel := strings.TrimPrefix(pkg, i.Project.Module+"/")
+	return filepath.Join(i.Project.ModuleDir, filepath.FromSlash(rel)), true
+}
+
+// 生成运行时包各文件，key 为目标路径，包名改为 RuntimeName
+func (i *InsPara) runtimeFiles() (map[string][]byte, error) {
+	dir, ok := i.runtimeDir()
+	if !ok {
+		log.Println(fmt.Sprintf("runtime package %s is outside the module %q, it will not be generated", i.runtimePkg(), i.Project.Module))
+		return nil, nil
+	}
+	entries, err := runtimeFS.ReadDir(runtimeSrcDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		src, err := runtimeFS.ReadFile(path.Join(runtimeSrcDir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		f.Name.Name = i.RuntimeName
+		if name == runtimeConfigFile {
+			i.configRuntime(f)
+		}
+
+		buffer := bytes.NewBufferString("")
+		if err := format.Node(buffer, fset, f); err != nil {
+			return nil, err
+		}
+		files[filepath.Join(dir, name)] = buffer.Bytes()
+	}
+	return files, nil
+}
+
+// 按配置改写运行时配置文件中变量的初始值
+func (i *InsPara) configRuntime(f *ast.File) {
+	values := map[string]ast.Expr{}
+	for name, v := range map[string]string{configExporter: i.Exporter, configExportFile: i.ExportFile, configExportEndpoint: i.ExportEndpoint} {
+		if v != "" {
+			values[name] = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(v)}
+		}
+	}
+	for _, pkg := range i.Project.Signals {
+		// 已生成的运行时包自己也处理信号
+		if pkg != i.runtimePkg() {
+			values[configOwnSignals] = ast.NewIdent("true")
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for idx, name := range spec.Names {
+			if v, ok := values[name.Name]; ok && idx < len(spec.Values) {
+				// 保持原位置，行尾注释不移动
+				switch v := v.(type) {
+				case *ast.BasicLit:
+					v.ValuePos = spec.Values[idx].Pos()
+				case *ast.Ident:
+					v.NamePos = spec.Values[idx].Pos()
+				}
+				spec.Values[idx] = v
+			}
+		}
+		return false
+	})
+}
+
+// 运行时包依赖 goid，模块未引入时给出提示
+func (i *InsPara) checkRuntimeDeps() {
+	gomod, err := os.ReadFile(filepath.Join(i.Project.ModuleDir, "go.mod"))
+	if err != nil || bytes.Contains(gomod, []byte(goidModule)) {
+		return
+	}
+	log.Println(fmt.Sprintf("the module does not require %s, run go get %s before building", goidModule, goidModule))
+}
@@ -0,0 +1,77 @@
+package instrument
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 处理信号的本地依赖
+const signalDep = `package dep
+
+import (
+	"os"
+	"os/signal"
+)
+
+func Watch() {
+	signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+}
+`
+
+func TestConfigOwnSignals(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "no signal handling",
+			files: map[string]string{"main.go": "package main\n\nfunc main() {}\n"},
+			want:  "false",
+		},
+		{
+			name: "module handles signals",
+			files: map[string]string{
+				"main.go": "package main\n\nfunc main() {}\n",
+				"sig/sig.go": `package sig
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+func Context() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+`,
+			},
+			want: "true",
+		},
+		{
+			name: "only a dependency handles signals",
+			files: map[string]string{
+				"go.mod":     testGoMod + "\nrequire example.com/dep v0.0.0\n\nreplace example.com/dep => ./dep\n",
+				"dep/go.mod": "module example.com/dep\n\ngo 1.16\n",
+				"dep/dep.go": signalDep,
+				"main.go":    "package main\n\nimport \"example.com/dep\"\n\nfunc main() { dep.Watch() }\n",
+			},
+			want: "false",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := instrumentTree(t, writeModule(t, tt.files), func(i *InsPara) {
+				i.Detectors = []EntryDetector{mainDetector{}}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := readFile(t, filepath.Join(out, PackageName, "config.go"))
+			if want := "configOwnSignals     = " + tt.want; !strings.Contains(config, want) {
+				t.Errorf("config lacks %q:\n%s", want, config)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package instrument
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+var (
+	ErrVerify = errors.New("instrumented code does not compile")
+)
+
+// 改写后的编译错误
+type verifyError struct {
+	pos  token.Position // 文件名为源文件路径
+	msg  string
+	fun  string // 所在的被改写函数
+	stmt string // 所在语句
+}
+
+func (e *verifyError) String() string {
+	s := fmt.Sprintf("%s: %s", e.pos, e.msg)
+	if e.fun != "" {
+		s += fmt.Sprintf("\n\tin %s", e.fun)
+	}
+	if e.stmt != "" {
+		s += fmt.Sprintf("\n\t> %s", e.stmt)
+	}
+	return s
+}
+
+// 重新加载改写后的包并做类型检查，只检查包含改写文件的包
+// base 为改写结果所在的模块根目录，overlay 非空时以其内容替代磁盘文件
+func (i *InsPara) verify(srcs map[string][]byte, base string, overlay map[string][]byte) error {
+	modRoot, err := moduleRoot(i.RootDir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(modRoot, i.RootDir)
+	if err != nil {
+		return err
+	}
+
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName |
+			packages.NeedFiles |
+			packages.NeedCompiledGoFiles |
+			packages.NeedImports |
+			packages.NeedTypes,
+		Tests:   i.Tests,
+		Dir:     filepath.Join(base, rel),
+		Overlay: overlay,
+	}, "./...")
+	if err != nil {
+		return err
+	}
+
+	// 输出目录中的文件对应回源文件
+	origin := func(filename string) string {
+		r, err := filepath.Rel(base, filename)
+		if err != nil || strings.HasPrefix(r, "..") {
+			return filename
+		}
+		return filepath.Join(modRoot, r)
+	}
+
+	errs := []*verifyError{}
+	seen := make(map[string]struct{}) // 包的测试变体会重复报告同一错误
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		rewritten := false
+		for _, f := range append(pkg.GoFiles, pkg.CompiledGoFiles...) {
+			if _, ok := srcs[origin(f)]; ok {
+				rewritten = true
+				break
+			}
+		}
+		if !rewritten {
+			return
+		}
+		for _, e := range pkg.Errors {
+			if _, ok := seen[e.Pos+e.Msg]; ok {
+				continue
+			}
+			seen[e.Pos+e.Msg] = struct{}{}
+			ve := &verifyError{pos: parsePos(e.Pos), msg: e.Msg}
+			ve.pos.Filename = origin(ve.pos.Filename)
+			if src, ok := srcs[ve.pos.Filename]; ok {
+				ve.fun, ve.stmt = i.locate(ve.pos, src)
+			}
+			errs = append(errs, ve)
+		}
+	})
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(errs, func(a, b int) bool {
+		if errs[a].pos.Filename != errs[b].pos.Filename {
+			return errs[a].pos.Filename < errs[b].pos.Filename
+		}
+		return errs[a].pos.Line < errs[b].pos.Line
+	})
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.String())
+	}
+	return fmt.Errorf("%w:\n%s", ErrVerify, strings.Join(msgs, "\n"))
+}
+
+// 解析 packages.Error 中 file:line:col 格式的位置
+func parsePos(s string) token.Position {
+	pos := token.Position{Filename: s}
+	nums := []int{}
+	for len(nums) < 2 {
+		idx := strings.LastIndex(pos.Filename, ":")
+		if idx < 0 {
+			break
+		}
+		n, err := strconv.Atoi(pos.Filename[idx+1:])
+		if err != nil {
+			break
+		}
+		nums = append(nums, n)
+		pos.Filename = pos.Filename[:idx]
+	}
+	switch len(nums) {
+	case 1:
+		pos.Line = nums[0]
+	case 2:
+		pos.Line, pos.Column = nums[1], nums[0]
+	}
+	return pos
+}
+
+// 在改写后的源码中找到错误所在的函数与语句
+func (i *InsPara) locate(pos token.Position, src []byte) (string, string) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, pos.Filename, src, 0)
+	if err != nil || pos.Line <= 0 || pos.Line > fset.File(f.Pos()).LineCount() {
+		return "", ""
+	}
+	tf := fset.File(f.Pos())
+	p := tf.LineStart(pos.Line)
+	if pos.Column > 1 && tf.Offset(p)+pos.Column-1 < tf.Size() {
+		p += token.Pos(pos.Column - 1)
+	}
+
+	var decl *ast.FuncDecl
+	var stmt ast.Stmt
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil || p < n.Pos() || p >= n.End() {
+			return false
+		}
+		switch t := n.(type) {
+		case *ast.FuncDecl:
+			decl = t
+		case *ast.BlockStmt:
+		case ast.Stmt:
+			stmt = t
+		}
+		return true
+	})
+	if decl == nil {
+		return "", ""
+	}
+
+	text := ""
+	if stmt != nil {
+		text = string(src[tf.Offset(stmt.Pos()):tf.Offset(stmt.End())])
+		if idx := strings.Index(text, "\n"); idx >= 0 {
+			text = text[:idx] + " ..."
+		}
+	}
+	return i.funcName(pos.Filename, decl), text
+}
+
+// 函数声明对应的被改写函数名，找不到时使用声明中的名字
+func (i *InsPara) funcName(filename string, decl *ast.FuncDecl) string {
+	recv := recvName(decl)
+	for m := range i.visited {
+		if m.File != filename || m.Fun == nil || m.Fun.Parent() != nil || m.Fun.Name() != decl.Name.Name {
+			continue
+		}
+		r := ""
+		if sr := m.Fun.Signature.Recv(); sr != nil {
+			t := sr.Type()
+			if ptr, ok := t.(*types.Pointer); ok {
+				t = ptr.Elem()
+			}
+			if named, ok := t.(*types.Named); ok {
+				r = named.Obj().Name()
+			}
+		}
+		if r == recv {
+			return m.Name
+		}
+	}
+	if recv != "" {
+		return recv + "." + decl.Name.Name
+	}
+	return decl.Name.Name
+}
+
+// 方法接收者的类型名
+func recvName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return ""
+	}
+	t := decl.Recv.List[0].Type
+	for {
+		switch e := t.(type) {
+		case *ast.StarExpr:
+			t = e.X
+		case *ast.ParenExpr:
+			t = e.X
+		case *ast.IndexExpr:
+			t = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
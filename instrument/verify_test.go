@@ -0,0 +1,85 @@
+package instrument
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 运行时包为模块外的空包，插入的调用都无法编译
+var brokenRuntime = map[string]string{
+	"go.mod":    testGoMod + "\nrequire example.com/rt v0.0.0\n\nreplace example.com/rt => ./rt\n",
+	"rt/go.mod": "module example.com/rt\n\ngo 1.16\n",
+	"rt/rt.go":  "package rt\n",
+	"main.go": `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println(work(1))
+}
+`,
+	"work.go": `package main
+
+//tracing:entry
+func work(n int) int {
+	return n * 2
+}
+`,
+}
+
+func TestVerifyRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		mode OutputMode
+	}{
+		{name: "in place", mode: InPlace},
+		{name: "output tree", mode: OutputTree},
+		{name: "overlay", mode: Overlay},
+		{name: "diff", mode: Diff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := writeModule(t, brokenRuntime)
+			i, err := NewInstrument(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i.Mode, i.DiffOutput = tt.mode, &strings.Builder{}
+			i.RuntimePkg, i.RuntimeName = "example.com/rt", "rt"
+			i.Detectors = []EntryDetector{mainDetector{}, directiveDetector{}}
+
+			err = i.Instrument()
+			if !errors.Is(err, ErrVerify) {
+				t.Fatalf("got %v, want %v", err, ErrVerify)
+			}
+			// 错误对应回源文件中的函数与语句
+			for _, want := range []string{
+				filepath.Join(root, "main.go") + ":",
+				"in example.com/app.main\n\t> rt.StartMainMode()",
+				filepath.Join(root, "work.go") + ":",
+				"in example.com/app.work",
+			} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error lacks %q:\n%v", want, err)
+				}
+			}
+
+			for name, src := range brokenRuntime {
+				if got := readFile(t, filepath.Join(root, name)); got != src {
+					t.Errorf("%s is not rolled back:\n%s", name, got)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(root, ManifestDir)); !os.IsNotExist(err) {
+				t.Errorf("manifest is left after rollback")
+			}
+			if i.OutputDir != "" {
+				if _, err := os.Stat(i.OutputDir); !os.IsNotExist(err) {
+					t.Errorf("temp dir %s is left after rollback", i.OutputDir)
+				}
+			}
+		})
+	}
+}
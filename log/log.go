@@ -85,3 +85,8 @@ func Fatalln(err error) {
 
 	l.logger.Panicf("ERROR %s:%d %v", filename, line, err)
 }
+
+// SetLevel 设置日志级别，低于该级别的日志不输出
+func SetLevel(level LLevel) {
+	l.level = level
+}
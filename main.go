@@ -1,7 +1,349 @@
 package main
 
-import "fmt"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+	"github.com/Shanjm/tracing-aspect/callgraph"
+	"github.com/Shanjm/tracing-aspect/instrument"
+	"github.com/Shanjm/tracing-aspect/log"
+	"github.com/Shanjm/tracing-aspect/selector"
+)
+
+// 退出码
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// 子命令
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []*command{
+	{name: "instrument", usage: "rewrite the project sources with tracing code", run: runInstrument},
+	{name: "analyze", usage: "list the members found in the project", run: runAnalyze},
+	{name: "callgraph", usage: "print the call graph of the project", run: runCallgraph},
+	{name: "restore", usage: "revert the files rewritten by instrument", run: runRestore},
+}
+
+// 参数错误，以 exitUsage 退出
+var errUsage = errors.New("usage error")
 
 func main() {
-	fmt.Println("hello world")
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		usage(os.Stderr)
+		return exitUsage
+	}
+
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		err := c.run(args[1:])
+		switch {
+		case err == nil:
+			return exitOK
+		case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+			return exitUsage
+		default:
+			fmt.Fprintf(os.Stderr, "tracing-aspect %s: %v\n", c.name, err)
+			return exitError
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "tracing-aspect: unknown command %q\n", args[0])
+	usage(os.Stderr)
+	return exitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: tracing-aspect <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "run 'tracing-aspect <command> -h' for the flags of a command.")
+}
+
+// 多次出现的参数
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// 各子命令的公共参数
+type commonFlags struct {
+	root    string
+	output  string
+	verbose bool
+}
+
+func newFlagSet(name string, cf *commonFlags, outputUsage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cf.root, "root", ".", "root `dir` of the project")
+	if outputUsage != "" {
+		fs.StringVar(&cf.output, "o", "", outputUsage)
+	}
+	fs.BoolVar(&cf.verbose, "v", false, "print verbose logs")
+	return fs
+}
+
+// 解析参数并设置日志级别
+func parseFlags(fs *flag.FlagSet, cf *commonFlags, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return errUsage
+	}
+	if !cf.verbose {
+		log.SetLevel(log.ERROR)
+	}
+	return nil
+}
+
+// 打开输出
+func (cf *commonFlags) openOutput() (io.WriteCloser, error) {
+	if cf.output == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(cf.output)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func runInstrument(args []string) error {
+	cf := &commonFlags{}
+	var entries listFlag
+	fs := newFlagSet("instrument", cf, "write an instrumented copy of the module to `dir` instead of rewriting the sources")
+	fs.Var(&entries, "entry", "`regexp` of the entry functions, can be repeated")
+	entryMode := fs.String("entry-mode", "", "comma separated entry `detectors`: http, grpc, main, init, test, config (-entry), directive (//tracing:entry) or all;\n"+
+		"by default directive plus config with -entry, otherwise grpc, http and main unless the project has //tracing:entry")
+	tmp := fs.Bool("tmp", false, "write an instrumented copy of the module to a temp dir")
+	diff := fs.Bool("diff", false, "print a unified diff of the rewritten files instead of writing them")
+	runtimePkg := fs.String("runtime-pkg", "", "import `path` of the runtime package, defaults to <root package>/goreport")
+	runtimeName := fs.String("runtime-name", instrument.PackageName, "package `name` of the runtime, also its import name in the rewritten files")
+	parentIdName := fs.String("parent-id-name", instrument.ParentId, "`name` of the variable holding the parent goroutine id")
+	varPrefix := fs.String("var-prefix", instrument.VarPrefix, "`prefix` of the other variables inserted into the rewritten functions, e.g. _arg_0, _ret_arg_0, _0")
+	overlay := fs.String("overlay", "", "leave the module untouched and write a go build -overlay `file`, the rewritten sources go to -o or a temp dir")
+	rules := fs.String("rules", "", "json `file` of the selection rules, the selected functions record their arguments and results")
+	var pointcuts listFlag
+	fs.Var(&pointcuts, "pointcut", "pointcut `expr` selecting functions like -rules, e.g. 'execution(* example.com/app/...*(..))', can be repeated")
+	advice := fs.String("advice", "", "import `path` of a package whose Before/After/Around functions are woven into the selected functions")
+	noVerify := fs.Bool("no-verify", false, "skip type-checking the rewritten packages; by default a failed check rolls the whole batch back")
+	tests := fs.Bool("tests", false, "also instrument the _test.go files, each test and benchmark becomes an entry")
+	exporter := fs.String("exporter", "", "default `exporter` of the traces: "+strings.Join(instrument.Exporters, ", ")+"; TRACING_EXPORTER overrides it at run time")
+	exportFile := fs.String("export-file", "", "default `file` the exporter writes to; TRACING_EXPORT_FILE overrides it at run time")
+	exportEndpoint := fs.String("export-endpoint", "", "default `url` the exporter posts to, e.g. http://localhost:4318/v1/traces for otlp; TRACING_EXPORT_ENDPOINT overrides it at run time")
+	if err := parseFlags(fs, cf, args); err != nil {
+		return err
+	}
+
+	ins, err := instrument.NewInstrument(cf.root)
+	if err != nil {
+		return err
+	}
+	ins.Entries = entries
+	if *entryMode != "" {
+		if ins.Detectors, err = instrument.ParseEntryModes(strings.Split(*entryMode, ","), entries); err != nil {
+			return err
+		}
+	}
+	if ins.Rules, err = loadRules(*rules, pointcuts); err != nil {
+		return err
+	}
+	ins.RuntimePkg = *runtimePkg
+	ins.RuntimeName = *runtimeName
+	ins.ParentIdName = *parentIdName
+	ins.VarPrefix = *varPrefix
+	ins.Advice = *advice
+	ins.SkipVerify = *noVerify
+	ins.Tests = *tests
+	ins.Exporter = *exporter
+	ins.ExportFile = *exportFile
+	ins.ExportEndpoint = *exportEndpoint
+	switch {
+	case *diff:
+		ins.Mode = instrument.Diff
+	case *overlay != "":
+		ins.Mode = instrument.Overlay
+		ins.OutputDir = cf.output
+		ins.OverlayFile = *overlay
+	case cf.output != "" || *tmp:
+		ins.Mode = instrument.OutputTree
+		ins.OutputDir = cf.output
+	}
+	if err := ins.Instrument(); err != nil {
+		return err
+	}
+	switch ins.Mode {
+	case instrument.OutputTree:
+		fmt.Println(ins.OutputDir)
+	case instrument.Overlay:
+		fmt.Println(ins.OverlayFile)
+	}
+	return nil
+}
+
+// 读取规则文件，每个切点表达式追加为一条包含规则
+func loadRules(filename string, pointcuts []string) ([]selector.Rule, error) {
+	rules := []selector.Rule{}
+	if filename != "" {
+		rs, err := selector.LoadRules(filename)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rs...)
+	}
+	for idx, pc := range pointcuts {
+		rules = append(rules, selector.Rule{
+			Name:     fmt.Sprintf("pointcut%d", idx+1),
+			Pointcut: pc,
+		})
+	}
+	return rules, nil
+}
+
+func runAnalyze(args []string) error {
+	cf := &commonFlags{}
+	fs := newFlagSet("analyze", cf, "output `file`, defaults to stdout")
+	rules := fs.String("rules", "", "json `file` of the selection rules, prints the functions each rule matches")
+	var pointcuts listFlag
+	fs.Var(&pointcuts, "pointcut", "pointcut `expr`, prints the functions it matches, can be repeated")
+	tests := fs.Bool("tests", false, "also load the _test.go files")
+	if err := parseFlags(fs, cf, args); err != nil {
+		return err
+	}
+	var sel *selector.Selector
+	if rs, err := loadRules(*rules, pointcuts); err != nil {
+		return err
+	} else if len(rs) > 0 {
+		if sel, err = selector.New(rs); err != nil {
+			return err
+		}
+	}
+
+	p, err := analysis.ParseProject(cf.root, *tests)
+	if err != nil {
+		return err
+	}
+	w, err := cf.openOutput()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	fmt.Fprintf(w, "root package: %s\n", p.RootPkg)
+	pkgs := make([]string, 0, len(p.Pm))
+	for pkgPath := range p.Pm {
+		pkgs = append(pkgs, pkgPath)
+	}
+	sort.Strings(pkgs)
+	for _, pkgPath := range pkgs {
+		pkg := p.Pm[pkgPath]
+		fmt.Fprintf(w, "package %s\n", pkgPath)
+		files := make([]string, 0, len(pkg.Fm))
+		for f := range pkg.Fm {
+			files = append(files, f)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			file := pkg.Fm[f]
+			fmt.Fprintf(w, "  file %s\n", f)
+			funcs := make([]string, 0, len(file.FunMember))
+			for name := range file.FunMember {
+				funcs = append(funcs, name)
+			}
+			sort.Strings(funcs)
+			for _, name := range funcs {
+				fmt.Fprintf(w, "    func  %s\n", name)
+			}
+			for _, m := range file.VarMember {
+				fmt.Fprintf(w, "    var   %s\n", m.Name)
+			}
+			for _, m := range file.ConstMember {
+				fmt.Fprintf(w, "    const %s\n", m.Name)
+			}
+			for _, m := range file.TypeMember {
+				fmt.Fprintf(w, "    type  %s\n", m.Name)
+			}
+		}
+	}
+	if sel != nil {
+		sel.Select(p).Report(w)
+	}
+	return nil
+}
+
+func runCallgraph(args []string) error {
+	cf := &commonFlags{}
+	fs := newFlagSet("callgraph", cf, "output `file`, defaults to stdout")
+	tests := fs.Bool("tests", false, "also load the _test.go files")
+	if err := parseFlags(fs, cf, args); err != nil {
+		return err
+	}
+
+	p, err := analysis.ParseProject(cf.root, *tests)
+	if err != nil {
+		return err
+	}
+	cm, err := callgraph.GenerateCallgraph(p)
+	if err != nil {
+		return err
+	}
+	w, err := cf.openOutput()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	edges := []string{}
+	for caller, callees := range cm {
+		for _, callee := range callees {
+			edges = append(edges, fmt.Sprintf("%s -> %s", caller.Name, callee.Name))
+		}
+	}
+	sort.Strings(edges)
+	for _, e := range edges {
+		fmt.Fprintln(w, e)
+	}
+	return nil
+}
+
+func runRestore(args []string) error {
+	cf := &commonFlags{}
+	fs := newFlagSet("restore", cf, "")
+	if err := parseFlags(fs, cf, args); err != nil {
+		return err
+	}
+	return instrument.Restore(cf.root)
 }
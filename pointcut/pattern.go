@@ -0,0 +1,380 @@
+package pointcut
+
+import (
+	"errors"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// 通配模式，编译为正则
+type pattern struct {
+	src string
+	re  *regexp.Regexp
+}
+
+func (p *pattern) match(s string) bool {
+	return p == nil || p.re.MatchString(s)
+}
+
+// 函数模式：[返回类型] [包.][(接收者).]函数名[(参数)]
+type funcPattern struct {
+	ret    *pattern   // 返回类型，nil 为任意
+	pkg    *pattern   // 包路径，nil 为任意
+	recv   *pattern   // 接收者类型名，nil 为不限
+	name   *pattern   // 函数名
+	params []*pattern // 参数类型，nil 为任意
+	rest   bool       // 参数以 .. 结尾，之后可以有任意个参数
+}
+
+// 被匹配的函数
+type target struct {
+	pkg  string
+	name string
+	sig  *types.Signature
+}
+
+func functionTarget(fn *ssa.Function) *target {
+	t := &target{name: fn.Name(), sig: fn.Signature}
+	if fn.Pkg != nil {
+		t.pkg = fn.Pkg.Pkg.Path()
+	} else if obj := fn.Object(); obj != nil && obj.Pkg() != nil {
+		t.pkg = obj.Pkg().Path()
+	}
+	return t
+}
+
+// 调用的目标函数，动态调用返回 nil
+func calleeTarget(c *ssa.CallCommon) *target {
+	if c.IsInvoke() {
+		t := &target{name: c.Method.Name(), sig: c.Method.Type().(*types.Signature)}
+		if c.Method.Pkg() != nil {
+			t.pkg = c.Method.Pkg().Path()
+		}
+		return t
+	}
+	if fn := c.StaticCallee(); fn != nil {
+		return functionTarget(fn)
+	}
+	return nil
+}
+
+func (p *funcPattern) match(t *target) bool {
+	if !p.pkg.match(t.pkg) || !p.name.match(t.name) {
+		return false
+	}
+	if p.recv != nil {
+		recv := t.sig.Recv()
+		if recv == nil || !p.recv.match(recvName(recv.Type())) {
+			return false
+		}
+	}
+	if p.ret != nil && !matchType(p.ret, results(t.sig)) {
+		return false
+	}
+	if p.params == nil {
+		return true
+	}
+	params := t.sig.Params()
+	if params.Len() < len(p.params) || (!p.rest && params.Len() != len(p.params)) {
+		return false
+	}
+	for idx, pp := range p.params {
+		if !matchType(pp, params.At(idx).Type()) {
+			return false
+		}
+	}
+	return true
+}
+
+// 接收者类型名，指针接收者带 * 前缀
+func recvName(t types.Type) string {
+	prefix := ""
+	if ptr, ok := t.(*types.Pointer); ok {
+		prefix, t = "*", ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return prefix + named.Obj().Name()
+	}
+	return prefix + t.String()
+}
+
+// 返回值整体作为一个类型，无返回值为 void，多返回值为 (a, b)
+func results(sig *types.Signature) types.Type {
+	switch sig.Results().Len() {
+	case 0:
+		return nil
+	case 1:
+		return sig.Results().At(0).Type()
+	default:
+		// 去掉返回值名字
+		vars := make([]*types.Var, sig.Results().Len())
+		for idx := range vars {
+			vars[idx] = types.NewVar(token.NoPos, nil, "", sig.Results().At(idx).Type())
+		}
+		return types.NewTuple(vars...)
+	}
+}
+
+// 类型可以写完整包路径 *net/http.Request，也可以只写包名 *http.Request
+func matchType(p *pattern, t types.Type) bool {
+	if t == nil {
+		return p.src == "void" || p.src == "*"
+	}
+	if p.match(types.TypeString(t, nil)) {
+		return true
+	}
+	return p.match(types.TypeString(t, func(pkg *types.Package) string {
+		return pkg.Name()
+	}))
+}
+
+// 解析函数模式
+func compileFunc(s string) (*funcPattern, error) {
+	p := &funcPattern{}
+	head := s
+
+	// 末尾的参数列表
+	if strings.HasSuffix(s, ")") {
+		idx := matchingOpen(s, len(s)-1)
+		if idx < 0 {
+			return nil, errors.New("unbalanced parentheses")
+		}
+		// (*T) 形式的接收者后必须跟 .函数名，不会出现在末尾
+		head = strings.TrimSpace(s[:idx])
+		args := strings.TrimSpace(s[idx+1 : len(s)-1])
+		p.params = []*pattern{}
+		for _, arg := range splitTop(args, ',') {
+			arg = strings.TrimSpace(arg)
+			if p.rest {
+				return nil, errors.New(".. must be the last parameter")
+			}
+			switch arg {
+			case "":
+				if args != "" {
+					return nil, errors.New("empty parameter")
+				}
+			case "..":
+				p.rest = true
+			default:
+				pp, err := compileType(arg)
+				if err != nil {
+					return nil, err
+				}
+				p.params = append(p.params, pp)
+			}
+		}
+		if p.rest && len(p.params) == 0 {
+			p.params = nil
+		}
+	}
+
+	// 返回类型与限定名以最后一个顶层空格分隔
+	qname := head
+	if idx := lastTop(head, ' '); idx >= 0 {
+		ret, err := compileType(strings.TrimSpace(head[:idx]))
+		if err != nil {
+			return nil, err
+		}
+		p.ret = ret
+		qname = strings.TrimSpace(head[idx+1:])
+	}
+
+	name := qname
+	if idx := strings.Index(qname, "("); idx >= 0 {
+		// 带接收者
+		end := strings.Index(qname, ")")
+		if end < idx || !strings.HasPrefix(qname[end+1:], ".") {
+			return nil, errors.New("receiver must look like (*T).name")
+		}
+		if idx > 0 {
+			if !strings.HasSuffix(qname[:idx], ".") {
+				return nil, errors.New("receiver must follow the package and a dot")
+			}
+			pkg, err := compilePackage(qname[:idx-1])
+			if err != nil {
+				return nil, err
+			}
+			p.pkg = pkg
+		}
+		recv, err := compileRecv(strings.TrimSpace(qname[idx+1 : end]))
+		if err != nil {
+			return nil, err
+		}
+		p.recv = recv
+		name = qname[end+2:]
+	} else if idx := strings.LastIndex(qname, "."); idx >= 0 {
+		pkg, err := compilePackage(qname[:idx])
+		if err != nil {
+			return nil, err
+		}
+		p.pkg = pkg
+		name = qname[idx+1:]
+	}
+	if name == "" {
+		return nil, errors.New("missing function name")
+	}
+	n, err := compile(name, func(b *strings.Builder, s string, i int) int {
+		if s[i] == '*' {
+			b.WriteString(".*")
+			return 1
+		}
+		return 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.name = n
+	return p, nil
+}
+
+// 包路径模式，* 匹配一级路径，... 匹配任意多级，结尾的 /... 同时匹配包本身
+func compilePackage(s string) (*pattern, error) {
+	if s == "" {
+		return nil, errors.New("empty package")
+	}
+	if s == "*" || s == "..." {
+		return nil, nil
+	}
+	return compile(s, func(b *strings.Builder, s string, i int) int {
+		switch {
+		case strings.HasPrefix(s[i:], "/...") && i+4 == len(s):
+			b.WriteString("(/.*)?")
+			return 4
+		case strings.HasPrefix(s[i:], "..."):
+			b.WriteString(".*")
+			return 3
+		case s[i] == '*':
+			b.WriteString("[^/]*")
+			return 1
+		}
+		return 0
+	})
+}
+
+// 接收者模式，* 单独出现时匹配任意接收者，*T 只匹配指针接收者
+func compileRecv(s string) (*pattern, error) {
+	if s == "*" {
+		return compile(s, func(b *strings.Builder, s string, i int) int {
+			b.WriteString(".*")
+			return 1
+		})
+	}
+	return compileType(s)
+}
+
+// 类型模式，单独的 * 匹配任意类型，紧跟标识符的 * 为指针，... 匹配任意字符
+func compileType(s string) (*pattern, error) {
+	if s == "" {
+		return nil, errors.New("empty type")
+	}
+	return compile(s, func(b *strings.Builder, s string, i int) int {
+		switch {
+		case strings.HasPrefix(s[i:], "..."):
+			b.WriteString(".*")
+			return 3
+		case s[i] != '*':
+			return 0
+		case isPointer(s, i):
+			b.WriteString(`\*`)
+			return 1
+		case (i == 0 || strings.ContainsRune("[](), ", rune(s[i-1]))) &&
+			(i+1 == len(s) || strings.ContainsRune("[](), ", rune(s[i+1]))):
+			// 独立的 * 为任意类型
+			b.WriteString(".*")
+			return 1
+		}
+		b.WriteString("[^/]*")
+		return 1
+	})
+}
+
+// * 位于类型开头且后跟标识符时表示指针
+func isPointer(s string, i int) bool {
+	if i+1 == len(s) {
+		return false
+	}
+	if i > 0 && !strings.ContainsRune("[]*(, ", rune(s[i-1])) {
+		return false
+	}
+	c := s[i+1]
+	return c == '_' || c == '*' || c == '[' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// 逐字符转为正则，special 处理通配符并返回消耗的字符数，0 表示按原字符处理
+func compile(s string, special func(b *strings.Builder, s string, i int) int) (*pattern, error) {
+	b := &strings.Builder{}
+	b.WriteString("^")
+	for i := 0; i < len(s); {
+		if n := special(b, s, i); n > 0 {
+			i += n
+			continue
+		}
+		b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+		i++
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, err
+	}
+	return &pattern{src: s, re: re}, nil
+}
+
+// 与 s[end] 处右括号匹配的左括号位置
+func matchingOpen(s string, end int) int {
+	depth := 0
+	for i := end; i >= 0; i-- {
+		switch s[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// 按不在括号内的分隔符切分
+func splitTop(s string, sep byte) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// 最后一个不在括号内的分隔符位置
+func lastTop(s string, sep byte) int {
+	depth := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		switch s[i] {
+		case ')', ']':
+			depth++
+		case '(', '[':
+			depth--
+		case sep:
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
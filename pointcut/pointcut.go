@@ -0,0 +1,238 @@
+// Package pointcut 切点表达式，语法参照 AspectJ
+//
+//	execution(* example.com/svc/util.(*Monster).*(..))  函数定义
+//	within(example.com/svc/...)                         包内的函数
+//	call(net/http.*)                                    调用了匹配函数的函数
+//
+// 表达式之间可以使用 &&、||、! 与括号组合
+package pointcut
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Pointcut 切点，匹配项目中的函数成员
+type Pointcut interface {
+	Match(m *analysis.Member) bool
+	String() string
+}
+
+type (
+	and struct{ x, y Pointcut }
+	or  struct{ x, y Pointcut }
+	not struct{ x Pointcut }
+
+	// 函数定义匹配
+	execution struct {
+		src string
+		pat *funcPattern
+	}
+
+	// 所在包匹配
+	within struct {
+		src string
+		pkg *pattern
+	}
+
+	// 函数内存在匹配的调用
+	call struct {
+		src string
+		pat *funcPattern
+	}
+)
+
+func (p *and) Match(m *analysis.Member) bool { return p.x.Match(m) && p.y.Match(m) }
+func (p *or) Match(m *analysis.Member) bool  { return p.x.Match(m) || p.y.Match(m) }
+func (p *not) Match(m *analysis.Member) bool { return !p.x.Match(m) }
+
+func (p *and) String() string { return fmt.Sprintf("(%s && %s)", p.x, p.y) }
+func (p *or) String() string  { return fmt.Sprintf("(%s || %s)", p.x, p.y) }
+func (p *not) String() string { return fmt.Sprintf("!%s", p.x) }
+
+func (p *execution) Match(m *analysis.Member) bool {
+	if m.Fun == nil {
+		return false
+	}
+	return p.pat.match(functionTarget(m.Fun))
+}
+
+func (p *within) Match(m *analysis.Member) bool {
+	return m.Pkg != nil && p.pkg.match(m.Pkg.Pkg.Path())
+}
+
+func (p *call) Match(m *analysis.Member) bool {
+	if m.Fun == nil {
+		return false
+	}
+	for _, b := range m.Fun.Blocks {
+		for _, instr := range b.Instrs {
+			c, ok := instr.(ssa.CallInstruction)
+			if !ok {
+				continue
+			}
+			if t := calleeTarget(c.Common()); t != nil && p.pat.match(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *execution) String() string { return fmt.Sprintf("execution(%s)", p.src) }
+func (p *within) String() string    { return fmt.Sprintf("within(%s)", p.src) }
+func (p *call) String() string      { return fmt.Sprintf("call(%s)", p.src) }
+
+// Parse 解析切点表达式
+func Parse(expr string) (Pointcut, error) {
+	p := &parser{src: expr}
+	pc, err := p.parseOr()
+	if err == nil {
+		p.skipSpace()
+		if p.pos < len(p.src) {
+			err = p.errorf("unexpected %q", p.src[p.pos:])
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pointcut %q: %w", expr, err)
+	}
+	return pc, nil
+}
+
+// 递归下降解析，优先级 ! > && > ||
+type parser struct {
+	src string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// 跳过空白后尝试读取 tok
+func (p *parser) consume(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (Pointcut, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &or{x, y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseAnd() (Pointcut, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &and{x, y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseUnary() (Pointcut, error) {
+	if p.consume("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &not{x}, nil
+	}
+	if p.consume("(") {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("missing )")
+		}
+		return x, nil
+	}
+	return p.parsePrimitive()
+}
+
+func (p *parser) parsePrimitive() (Pointcut, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] >= 'a' && p.src[p.pos] <= 'z') {
+		p.pos++
+	}
+	designator := p.src[start:p.pos]
+	if designator == "" {
+		if p.pos == len(p.src) {
+			return nil, p.errorf("unexpected end of expression")
+		}
+		return nil, p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	if !p.consume("(") {
+		return nil, p.errorf("missing ( after %s", designator)
+	}
+
+	// 读取到匹配的右括号
+	argStart, depth := p.pos, 1
+	for ; p.pos < len(p.src); p.pos++ {
+		switch p.src[p.pos] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if depth != 0 {
+		return nil, p.errorf("missing ) for %s", designator)
+	}
+	arg := strings.TrimSpace(p.src[argStart:p.pos])
+	p.pos++
+
+	switch designator {
+	case "execution", "call":
+		pat, err := compileFunc(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s(%s): %w", designator, arg, err)
+		}
+		if designator == "call" {
+			return &call{src: arg, pat: pat}, nil
+		}
+		return &execution{src: arg, pat: pat}, nil
+	case "within":
+		pkg, err := compilePackage(arg)
+		if err != nil {
+			return nil, fmt.Errorf("within(%s): %w", arg, err)
+		}
+		return &within{src: arg, pkg: pkg}, nil
+	default:
+		p.pos = start
+		return nil, p.errorf("unknown designator %q", designator)
+	}
+}
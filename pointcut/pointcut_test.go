@@ -0,0 +1,160 @@
+package pointcut
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+)
+
+const svcSrc = `package svc
+
+import "net/http"
+
+type Monster struct{}
+
+func (m *Monster) Eat(food string, n int) error { return nil }
+
+func (m Monster) String() string { return "monster" }
+
+func Fetch(url string) (*http.Response, error) { return http.Get(url) }
+
+func add(a, b int) int { return a + b }
+`
+
+const mainSrc = `package main
+
+import "example.com/app/svc"
+
+func main() {
+	m := &svc.Monster{}
+	m.Eat("x", 1)
+	svc.Fetch("")
+}
+`
+
+// 解析测试项目
+func parseProject(t *testing.T) *analysis.Project {
+	t.Helper()
+	root := t.TempDir()
+	for name, src := range map[string]string{
+		"go.mod":     "module example.com/app\n\ngo 1.16\n",
+		"svc/svc.go": svcSrc,
+		"main.go":    mainSrc,
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := analysis.ParseProject(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// 切点匹配到的函数，方法写作 接收者.方法名，函数写作 包名.函数名，排序后以空格连接
+func matched(p *analysis.Project, pc Pointcut) string {
+	names := []string{}
+	for _, pkg := range p.Pm {
+		for _, file := range pkg.Fm {
+			for _, m := range file.FunMember {
+				if !pc.Match(m) {
+					continue
+				}
+				if recv := m.Fun.Signature.Recv(); recv != nil {
+					names = append(names, recvName(recv.Type())+"."+m.Fun.Name())
+				} else {
+					names = append(names, m.Pkg.Pkg.Name()+"."+m.Fun.Name())
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestMatch(t *testing.T) {
+	p := parseProject(t)
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"within(example.com/app/svc)", "*Monster.Eat Monster.String svc.Fetch svc.add"},
+		{"within(example.com/app/...)", "*Monster.Eat Monster.String main.main svc.Fetch svc.add"},
+		{"within(example.com/*)", "main.main"},
+		{"execution(* *(..))", "*Monster.Eat Monster.String main.main svc.Fetch svc.add"},
+		{"execution(* example.com/app/svc.(*Monster).*(..))", "*Monster.Eat"},
+		{"execution(* (*).*(..))", "*Monster.Eat Monster.String"},
+		{"execution(* (Monster).*(..))", "Monster.String"},
+		{"execution(error *(string, int))", "*Monster.Eat"},
+		{"execution(* *(string, ..))", "*Monster.Eat svc.Fetch"},
+		{"execution(* *(int, *))", "svc.add"},
+		{"execution(void main())", "main.main"},
+		{"execution(string *())", "Monster.String"},
+		{"execution((*http.Response, error) *(..))", "svc.Fetch"},
+		{"execution((*net/http.Response, error) *(..))", "svc.Fetch"},
+		{"execution(* F*)", "svc.Fetch"},
+		{"execution(* example.com/.../svc.*(..))", "*Monster.Eat Monster.String svc.Fetch svc.add"},
+		{"call(net/http.Get)", "svc.Fetch"},
+		{"call(* (*Monster).Eat(..))", "main.main"},
+		{"call(* *.Post(..))", ""},
+		{"within(example.com/app/svc) && !execution(* *.String())", "*Monster.Eat svc.Fetch svc.add"},
+		{"execution(* add(..)) || call(net/http.*)", "svc.Fetch svc.add"},
+		{"!(within(example.com/app/svc) || call(*))", ""},
+		{"!!within(example.com/app)", "main.main"},
+	}
+	for _, tt := range tests {
+		pc, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := matched(p, pc); got != tt.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string // String() 的结果或错误信息的一部分
+		err  bool
+	}{
+		{expr: "within(a/b)", want: "within(a/b)"},
+		{expr: " execution( * f(..) ) ", want: "execution(* f(..))"},
+		{expr: "a(x) && b(y)", want: `unknown designator "a"`, err: true},
+		{expr: "within(a) && within(b) || !within(c)", want: "((within(a) && within(b)) || !within(c))"},
+		{expr: "within(a) && (within(b) || within(c))", want: "(within(a) && (within(b) || within(c)))"},
+		{expr: "", want: "unexpected end of expression", err: true},
+		{expr: "within(a) within(b)", want: `unexpected "within(b)"`, err: true},
+		{expr: "within(a", want: "missing ) for within", err: true},
+		{expr: "(within(a)", want: "missing )", err: true},
+		{expr: "within", want: "missing ( after within", err: true},
+		{expr: "within(a) &&", want: "unexpected end of expression", err: true},
+	}
+	for _, tt := range tests {
+		pc, err := Parse(tt.expr)
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%q: got error %v, want %q", tt.expr, err, tt.want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		if got := pc.String(); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
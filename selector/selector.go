@@ -0,0 +1,243 @@
+package selector
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+	"github.com/Shanjm/tracing-aspect/pointcut"
+)
+
+// Rule 函数选择规则，各条件之间为且的关系，未设置的条件不参与匹配
+type Rule struct {
+	Name     string `json:"name"`     // 规则名，用于匹配报告，为空则使用序号
+	Exclude  bool   `json:"exclude"`  // 排除规则，优先于包含规则
+	Package  string `json:"package"`  // 包路径 glob，以 /... 结尾时同时匹配子包
+	Receiver string `json:"receiver"` // 接收者类型名 glob，以 * 开头时只匹配指针接收者
+	Func     string `json:"func"`     // 函数名正则
+	File     string `json:"file"`     // 文件路径 glob，相对模块根目录，不含 / 时只匹配文件名
+	Exported *bool  `json:"exported"` // 是否导出
+	Pointcut string `json:"pointcut"` // 切点表达式，见 pointcut 包
+}
+
+// 编译后的规则
+type rule struct {
+	*Rule
+	funcRe *regexp.Regexp
+	pc     pointcut.Pointcut
+}
+
+// Selector 根据规则从项目中选择函数
+type Selector struct {
+	rules []*rule
+}
+
+// Result 选择结果
+type Result struct {
+	Selected map[string]*analysis.Member   // 选中的函数，key 为函数名
+	Matches  map[string][]*analysis.Member // 各规则匹配到的函数，key 为规则名
+	Rules    []string                      // 规则名，保持配置中的顺序
+}
+
+// LoadRules 读取 json 格式的规则文件，内容为 Rule 数组
+func LoadRules(filename string) ([]Rule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	rules := []Rule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+// New 编译规则
+func New(rules []Rule) (*Selector, error) {
+	s := &Selector{}
+	names := make(map[string]struct{}, len(rules))
+	for idx := range rules {
+		r := &rule{Rule: &rules[idx]}
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule%d", idx+1)
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("duplicate rule name %q", r.Name)
+		}
+		names[r.Name] = struct{}{}
+
+		if r.Func != "" {
+			re, err := regexp.Compile(r.Func)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: invalid func %q: %w", r.Name, r.Func, err)
+			}
+			r.funcRe = re
+		}
+		if r.Pointcut != "" {
+			pc, err := pointcut.Parse(r.Pointcut)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+			}
+			r.pc = pc
+		}
+		for _, pattern := range []string{strings.TrimSuffix(r.Package, "/..."), strings.TrimPrefix(r.Receiver, "*"), r.File} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %s: invalid pattern %q: %w", r.Name, pattern, err)
+			}
+		}
+		s.rules = append(s.rules, r)
+	}
+	return s, nil
+}
+
+// Select 对项目中所有函数求值
+// 匹配任一包含规则且不匹配任何排除规则的函数被选中，只有排除规则时其余函数全部选中
+func (s *Selector) Select(p *analysis.Project) *Result {
+	res := &Result{
+		Selected: make(map[string]*analysis.Member),
+		Matches:  make(map[string][]*analysis.Member),
+	}
+	hasInclude := false
+	for _, r := range s.rules {
+		res.Rules = append(res.Rules, r.Name)
+		if !r.Exclude {
+			hasInclude = true
+		}
+	}
+	if len(s.rules) == 0 {
+		return res
+	}
+
+	for _, pkg := range p.Pm {
+		for _, file := range pkg.Fm {
+			for _, m := range file.FunMember {
+				included, excluded := !hasInclude, false
+				for _, r := range s.rules {
+					if !r.match(p, m) {
+						continue
+					}
+					res.Matches[r.Name] = append(res.Matches[r.Name], m)
+					if r.Exclude {
+						excluded = true
+					} else {
+						included = true
+					}
+				}
+				if included && !excluded {
+					res.Selected[m.Name] = m
+				}
+			}
+		}
+	}
+
+	for _, ms := range res.Matches {
+		sort.Slice(ms, func(a, b int) bool {
+			return ms[a].Name < ms[b].Name
+		})
+	}
+	return res
+}
+
+// Report 输出各规则匹配到的函数
+func (res *Result) Report(w io.Writer) {
+	for _, name := range res.Rules {
+		ms := res.Matches[name]
+		fmt.Fprintf(w, "rule %s: %d matched\n", name, len(ms))
+		for _, m := range ms {
+			mark := " "
+			if _, ok := res.Selected[m.Name]; ok {
+				mark = "+"
+			}
+			fmt.Fprintf(w, "  %s %s\n", mark, m.Name)
+		}
+	}
+	fmt.Fprintf(w, "%d functions selected\n", len(res.Selected))
+}
+
+func (r *rule) match(p *analysis.Project, m *analysis.Member) bool {
+	if m.Fun == nil {
+		return false
+	}
+	if r.Package != "" && !matchPackage(r.Package, m.Pkg.Pkg.Path()) {
+		return false
+	}
+	if r.Receiver != "" && !matchReceiver(r.Receiver, m) {
+		return false
+	}
+	if r.funcRe != nil && !r.funcRe.MatchString(m.Fun.Name()) {
+		return false
+	}
+	if r.File != "" && !matchFile(r.File, p.ModuleDir, m.File) {
+		return false
+	}
+	if r.Exported != nil && *r.Exported != (m.Fun.Parent() == nil && ast.IsExported(m.Fun.Name())) {
+		return false
+	}
+	if r.pc != nil && !r.pc.Match(m) {
+		return false
+	}
+	return true
+}
+
+func matchPackage(pattern, pkgPath string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+		if ok, _ := path.Match(prefix, pkgPath); ok {
+			return true
+		}
+		// 逐级匹配上层包
+		for dir := path.Dir(pkgPath); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if ok, _ := path.Match(prefix, dir); ok {
+				return true
+			}
+		}
+		return false
+	}
+	ok, _ := path.Match(pattern, pkgPath)
+	return ok
+}
+
+func matchReceiver(pattern string, m *analysis.Member) bool {
+	recv := m.Fun.Signature.Recv()
+	if recv == nil {
+		return false
+	}
+	t := recv.Type()
+	ptr, isPtr := t.(*types.Pointer)
+	if isPtr {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	if strings.HasPrefix(pattern, "*") {
+		if !isPtr {
+			return false
+		}
+		pattern = pattern[1:]
+	}
+	ok, _ = path.Match(pattern, named.Obj().Name())
+	return ok
+}
+
+func matchFile(pattern, root, filename string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, filepath.Base(filename))
+		return ok
+	}
+	rel, err := filepath.Rel(root, filename)
+	if err != nil {
+		return false
+	}
+	ok, _ := path.Match(pattern, filepath.ToSlash(rel))
+	return ok
+}
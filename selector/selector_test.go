@@ -0,0 +1,214 @@
+package selector
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Shanjm/tracing-aspect/analysis"
+)
+
+// 测试项目的源码，key 为相对模块根目录的路径
+var sources = map[string]string{
+	"go.mod": "module example.com/app\n\ngo 1.16\n",
+	"main.go": `package main
+
+import "example.com/app/service/engine"
+
+func main() {
+	e := &engine.Engine{}
+	e.ServeHTTP()
+	e.Stop()
+}
+`,
+	"service/engine/engine.go": `package engine
+
+type Engine struct{}
+
+func (e *Engine) ServeHTTP() { e.serveConn() }
+
+func (e *Engine) serveConn() {}
+
+func (e Engine) Stop() {}
+`,
+	"service/engine/engine_gen.go": `package engine
+
+func Generated() {}
+`,
+	"util/util.go": `package util
+
+func Helper() {}
+`,
+}
+
+func parseProject(t *testing.T) *analysis.Project {
+	t.Helper()
+	root := t.TempDir()
+	for name, src := range sources {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := analysis.ParseProject(root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// 函数的短名，去掉模块前缀后排序，以空格连接
+func names(ms map[string]*analysis.Member) string {
+	list := []string{}
+	for name := range ms {
+		list = append(list, strings.ReplaceAll(name, "example.com/app/", ""))
+	}
+	sort.Strings(list)
+	return strings.Join(list, " ")
+}
+
+func TestSelect(t *testing.T) {
+	p := parseProject(t)
+	exported, unexported := true, false
+	tests := []struct {
+		name  string
+		rules []Rule
+		want  string
+	}{
+		{name: "no rules", want: ""},
+		{
+			name:  "package",
+			rules: []Rule{{Package: "example.com/app/util"}},
+			want:  "util.Helper",
+		},
+		{
+			name:  "package and subpackages",
+			rules: []Rule{{Package: "example.com/app/service/..."}},
+			want:  "(*service/engine.Engine).ServeHTTP (*service/engine.Engine).serveConn (service/engine.Engine).Stop service/engine.Generated",
+		},
+		{
+			name:  "pointer receiver",
+			rules: []Rule{{Receiver: "*Engine"}},
+			want:  "(*service/engine.Engine).ServeHTTP (*service/engine.Engine).serveConn",
+		},
+		{
+			name:  "any receiver",
+			rules: []Rule{{Receiver: "Eng*"}},
+			want:  "(*service/engine.Engine).ServeHTTP (*service/engine.Engine).serveConn (service/engine.Engine).Stop",
+		},
+		{
+			name:  "func and receiver",
+			rules: []Rule{{Receiver: "*Engine", Func: "^Serve"}},
+			want:  "(*service/engine.Engine).ServeHTTP",
+		},
+		{
+			name:  "file name",
+			rules: []Rule{{File: "*_gen.go"}},
+			want:  "service/engine.Generated",
+		},
+		{
+			name:  "file path",
+			rules: []Rule{{File: "service/*/engine.go", Exported: &unexported}},
+			want:  "(*service/engine.Engine).serveConn",
+		},
+		{
+			name:  "only exclude rules",
+			rules: []Rule{{Exclude: true, File: "*_gen.go"}, {Exclude: true, Package: "example.com/app/service/..."}},
+			want:  "example.com/app.main util.Helper",
+		},
+		{
+			name:  "exclude wins",
+			rules: []Rule{{Exported: &exported}, {Exclude: true, Func: "^Serve"}},
+			want:  "(service/engine.Engine).Stop service/engine.Generated util.Helper",
+		},
+		{
+			name:  "pointcut",
+			rules: []Rule{{Pointcut: "call(* (*Engine).serveConn(..)) || execution(void main())"}},
+			want:  "(*service/engine.Engine).ServeHTTP example.com/app.main",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(s.Select(p).Selected); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	p := parseProject(t)
+	s, err := New([]Rule{{Name: "serve", Func: "^Serve"}, {Exclude: true, Package: "example.com/app/service/..."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &strings.Builder{}
+	s.Select(p).Report(b)
+	want := `rule serve: 1 matched
+    (*example.com/app/service/engine.Engine).ServeHTTP
+rule rule2: 4 matched
+    (*example.com/app/service/engine.Engine).ServeHTTP
+    (*example.com/app/service/engine.Engine).serveConn
+    (example.com/app/service/engine.Engine).Stop
+    example.com/app/service/engine.Generated
+0 functions selected
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		err   string // 错误信息的一部分
+	}{
+		{name: "duplicate name", rules: []Rule{{Name: "a"}, {Name: "a"}}, err: `duplicate rule name "a"`},
+		{name: "default name taken", rules: []Rule{{Name: "rule2"}, {}}, err: `duplicate rule name "rule2"`},
+		{name: "func", rules: []Rule{{Func: "("}}, err: "rule rule1: invalid func"},
+		{name: "package", rules: []Rule{{Package: "a/[b"}}, err: `rule rule1: invalid pattern "a/[b"`},
+		{name: "file", rules: []Rule{{File: "[.go"}}, err: `invalid pattern "[.go"`},
+		{name: "pointcut", rules: []Rule{{Name: "pc", Pointcut: "within(a"}}, err: "rule pc: pointcut"},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.rules); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		content string
+		want    int
+		err     bool
+	}{
+		{content: `[{"package": "example.com/app/..."}, {"exclude": true, "file": "*_gen.go"}]`, want: 2},
+		{content: `[]`, want: 0},
+		{content: `{"package": "a"}`, err: true},
+	}
+	for idx, tt := range tests {
+		path := filepath.Join(dir, "rules.json")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rules, err := LoadRules(path)
+		if (err != nil) != tt.err || len(rules) != tt.want {
+			t.Errorf("case %d: got %d rules, error %v", idx, len(rules), err)
+		}
+	}
+	if _, err := LoadRules(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file loaded")
+	}
+}